@@ -0,0 +1,113 @@
+//go:build go1.18
+// +build go1.18
+
+package lexer
+
+// TokenG is the typed version of Token, where the name has the type T.
+type TokenG[T comparable] struct {
+	Name T      // token name
+	Text []byte // token body
+	Raw  []byte // whole consumed text, it may differ from the body
+}
+
+// TokenMatcherG represents typed token's matcher function type. It's the same
+// as TokenMatcher, but returns the name of the type T. Not matched results
+// are treated as skips regardless of the returned name.
+type TokenMatcherG[T comparable] func([]byte) (bool, int, T, []byte)
+
+// LexerG is the typed version of Lexer, where token names have the type T.
+// It gives the compile-time safety when token names are an enum type.
+//
+//   type Kind int
+//
+//   const (
+//     WORD Kind = iota
+//     DIGIT
+//   )
+//
+//   l := NewLexerG[Kind](`price 12`)
+//   l.AddMatcher(TokenizeIfMatchesG(`[a-z]+`, WORD))
+//   l.AddMatcher(SkipIfMatchesG[Kind](`\s+`))
+//   l.AddMatcher(TokenizeIfMatchesG(`\d+`, DIGIT))
+type LexerG[T comparable] struct {
+	lexer        *Lexer     // underlying untyped lexer
+	currentToken *TokenG[T] // matched token
+}
+
+// NewLexerG creates new typed lexer with given input.
+func NewLexerG[T comparable](text string) *LexerG[T] {
+	return &LexerG[T]{lexer: NewLexer(text)}
+}
+
+// NewLexerGWithMatchers creates new typed lexer with given input and matchers.
+func NewLexerGWithMatchers[T comparable](text string, matchers []TokenMatcherG[T]) *LexerG[T] {
+	l := NewLexerG[T](text)
+	for _, m := range matchers {
+		l.AddMatcher(m)
+	}
+	return l
+}
+
+// AddMatcher adds new matcher to end of the matchers list. It returns the
+// lexer itself, so the calls can be chained.
+func (l *LexerG[T]) AddMatcher(fn TokenMatcherG[T]) *LexerG[T] {
+	l.lexer.AddMatcher(func(input []byte) (bool, int, interface{}, []byte) {
+		matched, shift, name, text := fn(input)
+		if !matched {
+			return false, shift, nil, nil
+		}
+		return true, shift, name, text
+	})
+	return l
+}
+
+// Scan scans for a new token. It returns false if can't find any new token.
+func (l *LexerG[T]) Scan() bool {
+	l.currentToken = nil
+	if !l.lexer.Scan() {
+		return false
+	}
+	token := l.lexer.Token()
+	l.currentToken = &TokenG[T]{Name: token.Name.(T), Text: token.Text, Raw: token.Raw}
+	return true
+}
+
+// Token returns current mached token.
+func (l *LexerG[T]) Token() *TokenG[T] {
+	return l.currentToken
+}
+
+// Err returns the error of scanning.
+func (l *LexerG[T]) Err() error {
+	return l.lexer.Error
+}
+
+// Pos returns the current byte offset into the input.
+func (l *LexerG[T]) Pos() int {
+	return l.lexer.Pos()
+}
+
+// Reset resets the current scan results.
+func (l *LexerG[T]) Reset() {
+	l.lexer.Reset()
+	l.currentToken = nil
+}
+
+// TokenizeIfMatchesG is the typed version of TokenizeIfMatches.
+func TokenizeIfMatchesG[T comparable](pattern string, tokenName T) TokenMatcherG[T] {
+	fn := TokenizeIfMatches(pattern, tokenName)
+	return func(input []byte) (bool, int, T, []byte) {
+		matched, shift, _, text := fn(input)
+		return matched, shift, tokenName, text
+	}
+}
+
+// SkipIfMatchesG is the typed version of SkipIfMatches.
+func SkipIfMatchesG[T comparable](pattern string) TokenMatcherG[T] {
+	fn := SkipIfMatches(pattern)
+	return func(input []byte) (bool, int, T, []byte) {
+		var name T
+		_, shift, _, _ := fn(input)
+		return false, shift, name, nil
+	}
+}
@@ -0,0 +1,54 @@
+//go:build go1.18
+// +build go1.18
+
+package lexer_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zoer/lexer"
+)
+
+type kind int
+
+const (
+	kindWord kind = iota
+	kindDigit
+)
+
+func TestLexerG_Scan(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexerGWithMatchers(`price 12`, []lexer.TokenMatcherG[kind]{
+		lexer.TokenizeIfMatchesG(`[a-z]+`, kindWord),
+		lexer.SkipIfMatchesG[kind](`\s+`),
+	})
+	l.AddMatcher(lexer.TokenizeIfMatchesG(`\d+`, kindDigit))
+
+	assert.True(l.Scan())
+	assert.Equal(kindWord, l.Token().Name)
+	assert.Equal([]byte("price"), l.Token().Text)
+	assert.True(l.Scan())
+	assert.Equal(kindDigit, l.Token().Name)
+	assert.Equal([]byte("12"), l.Token().Text)
+	assert.Equal(8, l.Pos())
+	assert.False(l.Scan())
+	assert.Nil(l.Token())
+	assert.NoError(l.Err())
+
+	l.Reset()
+	assert.True(l.Scan())
+	assert.Equal(kindWord, l.Token().Name)
+}
+
+func TestLexerG_ScanWithError(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexerG[kind](`12 foo`)
+	l.AddMatcher(lexer.TokenizeIfMatchesG(`\d+`, kindDigit))
+
+	assert.True(l.Scan())
+	assert.False(l.Scan())
+	assert.Error(l.Err())
+}
@@ -2,99 +2,1949 @@
 package lexer
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"reflect"
 	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+	"unsafe"
 )
 
 const (
-	cantMatchErrorMessage = `Can't match any existed matchers for the following text: %q`
+	cantMatchErrorMessage    = `Can't match any existed matchers at %d:%d (offset %d) for the following text: %q`
+	inputTooLongErrorMessage = `Input length %d exceeds the limit of %d bytes`
+	tokenTooLongErrorMessage = `Token length %d exceeds the limit of %d bytes for the following text: %q`
+	ambiguityErrorMessage    = `Matchers %v are ambiguous for the following text: %q`
+	markErrorMessage         = `Mark %d is out of the input range [0, %d]`
+	insertErrorMessage       = `Index %d is out of the matchers range [0, %d]`
+	rangeErrorMessage        = `Range [%d, %d] is out of the input range [0, %d]`
+	noMatchersErrorMessage   = `No matchers are registered`
+	zeroWidthErrorMessage    = `Matcher %d matches the empty string`
+	duplicateErrorMessage    = `Matchers %d and %d have the same pattern %q`
+	panicErrorMessage        = `Matcher %d panicked: %v`
+	tokenLimitErrorMessage   = `Token limit of %d is exceeded`
+	emptyTokenErrorMessage   = `Matcher %d matched an empty token at %d:%d`
+	unanchoredErrorMessage   = `Matcher %d matched at %d instead of the current position %d, is the '^' anchor missing?`
+	escapeErrorMessage       = `Unknown escape sequence %q`
+	seekIndentErrorMessage   = `Seek to %d isn't supported with the indentation, use Save and Restore`
+	overshiftErrorMessage    = `Matcher %d shift %d exceeds the remaining input of %d bytes at %d:%d`
+	unterminatedErrorMessage = `%q is not terminated with %q`
+	stepPreviewLength        = 32 // max length of the remaining input preview
+	errorSnippetLength       = 32 // default max length of the input snippet in errors
+)
+
+// Names of the tokens produced by the lexer itself.
+const (
+	ERROR = SyntheticName("ERROR") // unmatched input, see Lexer.ErrorRecovery
+)
+
+// SyntheticName is the type of names of the tokens produced by the lexer
+// itself rather than by matchers.
+type SyntheticName string
+
+// TokenKind is the category of the token, see Token.Kind.
+type TokenKind int
+
+// Kinds of the tokens.
+const (
+	TokenKindNormal TokenKind = iota // token produced by a matcher
+	TokenKindError                   // unmatched input, see Lexer.ErrorRecovery
+	TokenKindEOF                     // token of an EOF matcher
+	TokenKindTrivia                  // named skip, see CollectIfMatches
 )
 
 // Lexer contains the input text and token matchers.
 type Lexer struct {
-	Input        string         // string being scanned
-	Matchers     []TokenMatcher // tokens' matchers
+	Input        string         // string being scanned, see ResetWith
+	matchers     []TokenMatcher // tokens' matchers
+	meta         []matcherMeta  // matchers' additional info
+	input        []byte         // whole input being scanned
 	currentInput []byte         // current working input
+	line         int            // current line number, starting from 1
+	column       int            // current column number, starting from 1
+	lastCR       bool           // whether the last consumed byte is '\r'
+	skipped      int            // bytes skipped since the last token
 	currentToken *Token         // matched token
-	Error        error          // error of scanning
+	prevToken    *Token         // previously matched token
+	LastMatcher  int            // index of the matcher produced the token or -1
+	trivia       []*Token       // collected tokens, see CollectIfMatches
+	filters      []TokenFilter  // tokens' filters
+	skips        []TokenMatcher // skip matchers, see AddSkip
+	eofMatchers  []TokenMatcher // matchers of the end of input
+	eofNext      int            // index of the next EOF matcher to run
+	MaxInputLen  int            // max input length in bytes, 0 means unlimited
+	MaxTokenLen  int            // max token length in bytes, 0 means unlimited
+	MaxTokens    int            // max number of tokens, 0 means unlimited
+	produced     int            // number of produced tokens
+	Error        error          // error of scanning, see Err
+
+	// StrictAmbiguity makes Scan to fail when more than one matcher consumes
+	// the input at the same position.
+	StrictAmbiguity bool
+
+	// ErrorRecovery makes Scan to return a single rune ERROR token when no
+	// matcher matches the input, instead of failing. So the whole input can
+	// be scanned regardless of errors.
+	ErrorRecovery bool
+
+	// CopyTokenText makes Scan to copy the tokens' Text and Raw, so they don't
+	// refer to the input. It prevents aliasing bugs when tokens outlive
+	// the input or it's changed, and doesn't keep the whole input in memory.
+	CopyTokenText bool
+
+	// TabWidth is the number of columns a tab character takes, 1 by default.
+	// If TabStops is set, a tab moves the column to the next tab stop instead.
+	TabWidth int
+	TabStops bool
+
+	// CollectStats enables the matchers' and tokens' statistics, see Stats
+	// and NameCounts.
+	CollectStats bool
+
+	// ErrorSnippetLen is the max length in bytes of the input snippet in
+	// the can't match error, 32 by default. Longer input is truncated with
+	// an ellipsis.
+	ErrorSnippetLen int
+
+	// SkipFirst makes Scan to try the skip matchers added with AddSkip before
+	// the token matchers, instead of after them.
+	SkipFirst bool
+
+	// SkipBOM makes Scan to skip the UTF-8 byte order mark at the beginning
+	// of the input. It's consumed, but doesn't take a column.
+	SkipBOM bool
+
+	// PropagatePanics disables the recovery of the matchers' panics, see
+	// MatcherPanicError. It's useful during development to get the stack.
+	PropagatePanics bool
+
+	// StrictAnchors makes Scan to fail with UnanchoredMatchError when
+	// a matcher's body ends past the consumed input, i.e. it's found further
+	// in the input. It catches matchers with missing '^' anchor, but it's
+	// slow, so it's meant for development only. Bodies within the consumed
+	// input, like the content of a quoted string, and bodies which don't
+	// refer to the input aren't reported.
+	StrictAnchors bool
+
+	// LongestMatch makes Scan to try all the matchers and to pick the one
+	// which consumes the most input, instead of the first one which matches.
+	// The earlier matcher wins a tie unless the tie breaker is set, see
+	// SetTieBreaker.
+	LongestMatch bool
+
+	// PreferTokens makes the tokens to take precedence over the skips at
+	// the same position regardless of the matchers' order. By default
+	// the first matcher which consumes the input wins, so a skip added
+	// before a token matcher may swallow the token. In the LongestMatch
+	// mode a token wins over a longer skip as well.
+	PreferTokens bool
+
+	// tieBreaker reports whether the first candidate wins a tie, see
+	// SetTieBreaker
+	tieBreaker func(a, b MatchCandidate) bool
+
+	// names of the tokens to merge, see AddMergeRule
+	merges map[interface{}]bool
+
+	// matchers' statistics by index, see Stats
+	stats map[int]*MatcherStats
+
+	// numbers of the emitted tokens by name, see NameCounts
+	names map[interface{}]int
+
+	// pushback is the LIFO buffer of the tokens pushed back with UnScan.
+	pushback []*Token
+
+	// offsets of the produced tokens if MaxTokens is set, so Seek can rewind
+	// the number of the produced tokens
+	emitted []int
+
+	// pool of the tokens, see SetTokenPool
+	pool *sync.Pool
+
+	// hook called on every matcher's call, see SetTrace
+	trace func(matcherIndex int, matched bool, shift int)
+
+	// formatter of the unmatched input error, see SetErrorFormatter
+	errorFormatter func(offset int, remaining []byte) error
+
+	// names of the disabled matcher groups, see EnableGroup
+	disabled map[string]bool
+
+	// formatter of the tokens' names, see SetNameStringer
+	nameStringer func(interface{}) string
+
+	// result of the matchers kept by merge for the next scan
+	lookahead *matchResult
+
+	// token filled by the current ScanInto call
+	into *Token
+
+	// done is set when Scan returns false, see Scan
+	done bool
+
+	// sources of the input, see NewLexerMulti
+	sources []sourceSpan
+
+	// indentation tokens' names and the stack of the indentation widths,
+	// see EnableIndentation
+	indentName, dedentName interface{}
+	indents                []int
+	indentLine             int
+	indentation            bool
+}
+
+// Token represents the scanned token info.
+type Token struct {
+	Name interface{} // token name
+	Text []byte      // token body
+	Raw  []byte      // whole consumed text, it may differ from the body
+
+	Kind TokenKind // token's category
+
+	Line   int // line number of the token start, starting from 1
+	Column int // column number of the token start in runes, starting from 1
+	Offset int // byte offset of the token start in the input
+
+	LeadingSkip int // number of bytes skipped right before the token
+
+	Value interface{} // converted token body, see TokenizeWithValue
+
+	Source       string // name of the token's source, see NewLexerMulti
+	SourceOffset int    // byte offset of the token start in the source
+
+	Captures [][]byte // regexp submatches, see TokenizeCaptures
+
+	pool    *sync.Pool // pool the token is taken from, see Lexer.SetTokenPool
+	matcher int        // index of the matcher plus one, see Lexer.indent
+
+	stringer func(interface{}) string // see Lexer.SetNameStringer
+}
+
+// MatcherStats contains the matcher's statistics, see Lexer.Stats.
+type MatcherStats struct {
+	Attempts int // number of the matcher's calls
+	Matches  int // number of the calls which matched or consumed the input
+}
+
+// MatcherPanicError is the error of a matcher's panic recovered by Scan,
+// see Lexer.PropagatePanics.
+type MatcherPanicError struct {
+	Matcher int         // index of the matcher, -1 for the EOF matchers
+	Value   interface{} // recovered value
+}
+
+// Error returns the error message.
+func (e *MatcherPanicError) Error() string {
+	return fmt.Sprintf(panicErrorMessage, e.Matcher, e.Value)
+}
+
+// UnanchoredMatchError is the error of a match which is found past
+// the consumed input, see Lexer.StrictAnchors.
+type UnanchoredMatchError struct {
+	Matcher int // index of the matcher
+	Pos     int // current position
+	Offset  int // offset of the match from the current position
+}
+
+// Error returns the error message.
+func (e *UnanchoredMatchError) Error() string {
+	return fmt.Sprintf(unanchoredErrorMessage, e.Matcher, e.Pos+e.Offset, e.Pos)
+}
+
+// matcherInfo describes the regexp matcher, see describe.
+type matcherInfo struct {
+	re      *regexp.Regexp // matcher's regexp
+	pattern string         // matcher's original pattern
+	name    interface{}    // token's name, it's nil for the skips
+}
+
+// infos keeps the infos of the matchers built by the pattern constructors
+// like TokenizeIfMatches by the addresses of their closures, so the lexer
+// finds them without calling the matchers, see describe.
+var infos = struct {
+	sync.Mutex
+	m map[uintptr]*matcherInfo
+}{m: make(map[uintptr]*matcherInfo)}
+
+// closure is the header of the matcher's closure, see describe.
+type closure struct {
+	fn uintptr
+}
+
+// describe registers the info of the matcher built by the pattern
+// constructor and returns the matcher. The info is dropped along with
+// the matcher's closure, so the registry doesn't keep the matchers alive.
+func describe(fn TokenMatcher, info matcherInfo) TokenMatcher {
+	p := *(*unsafe.Pointer)(unsafe.Pointer(&fn))
+	infos.Lock()
+	infos.m[uintptr(p)] = &info
+	infos.Unlock()
+	runtime.SetFinalizer((*closure)(p), func(c *closure) {
+		infos.Lock()
+		delete(infos.m, uintptr(unsafe.Pointer(c)))
+		infos.Unlock()
+	})
+	return fn
+}
+
+// describedBy returns the info of the matcher registered by describe or nil.
+func describedBy(fn TokenMatcher) *matcherInfo {
+	if fn == nil {
+		return nil
+	}
+	p := *(*unsafe.Pointer)(unsafe.Pointer(&fn))
+	infos.Lock()
+	defer infos.Unlock()
+	return infos.m[uintptr(p)]
+}
+
+// utf8BOM is the UTF-8 byte order mark, see Lexer.SkipBOM.
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// State is the snapshot of the lexer's scan state, see Lexer.Save.
+type State struct {
+	currentInput []byte
+	line         int
+	column       int
+	lastCR       bool
+	skipped      int
+	currentToken *Token
+	prevToken    *Token
+	lastMatcher  int
+	trivia       []*Token
+	pushback     []*Token
+	eofNext      int
+	indents      []int
+	indentLine   int
+	produced     int
+	emitted      []int
+	done         bool
+	err          error
+}
+
+// MatchCandidate is the result of a matcher competing for the longest
+// match, see Lexer.SetTieBreaker.
+type MatchCandidate struct {
+	Matcher int         // index of the matcher
+	Matched bool        // whether it's a token or a skip
+	Shift   int         // number of bytes to consume
+	Name    interface{} // token name
+	Text    []byte      // token body
+}
+
+// matchResult is the result of the matchers at the input, see Lexer.merge.
+type matchResult struct {
+	input   []byte
+	index   int
+	matched bool
+	shift   int
+	name    interface{}
+	text    []byte
+}
+
+// StepResult contains the detailed information about a single scan step.
+type StepResult struct {
+	Ok        bool   // whether a new token was found
+	Token     *Token // matched token
+	Matcher   int    // index of the matcher produced the token or -1
+	Consumed  int    // consumed bytes, including the skipped ones
+	Pos       int    // position after the step
+	Remaining []byte // preview of the remaining input
+}
+
+// TokenMatcher represents token's matcher function type. It returns whether
+// a token is matched, the number of bytes to consume, the token name and body.
+// The results are treated by Scan as follows:
+//
+//   matched, shift > 0:   a token
+//   matched, shift == 0:  an error, since the empty token would be produced
+//                         forever, but it's the token for EOF matchers
+//   !matched, shift > 0:  a skip, its name is ignored unless it's built by
+//                         CollectIfMatches, which collects it as trivia
+//   !matched, shift == 0: no match, the next matcher is tried
+//
+// A matcher may return a *Token as the name to provide the whole token
+// itself, its Raw field is set by the lexer.
+// A matcher may return an error as the name to fail scanning with it.
+//
+// A matcher may look at the whole input to decide, the shift is the only
+// thing consumed, so it's fine to examine more bytes than it returns and
+// the rest of the input stays for the next matchers, e.g. a number matcher
+// may look past "1." in "1..2" and return only "1". A matcher must never
+// return the shift larger than the bytes it has matched, since the extra
+// bytes are silently lost for the following tokens.
+type TokenMatcher func([]byte) (bool, int, interface{}, []byte)
+
+// Match calls the matcher, so TokenMatcher implements Matcher.
+func (fn TokenMatcher) Match(input []byte) (bool, int, interface{}, []byte) {
+	return fn(input)
+}
+
+// Pattern returns the original pattern of the matcher built by the pattern
+// constructors like TokenizeIfMatches, or an empty string otherwise.
+func (fn TokenMatcher) Pattern() string {
+	if info := describedBy(fn); info != nil {
+		return info.pattern
+	}
+	return ""
+}
+
+// Name returns the name of the tokens of the matcher built by the pattern
+// constructors like TokenizeIfMatches, it's nil for the skips and the other
+// matchers.
+func (fn TokenMatcher) Name() interface{} {
+	if info := describedBy(fn); info != nil {
+		return info.name
+	}
+	return nil
+}
+
+// Matcher is the matcher which may carry its state and metadata, its Match
+// method is the same as TokenMatcher, see Lexer.Add. Both RegexpMatcher and
+// the matchers of the built-in pattern constructors like TokenizeIfMatches
+// also provide their Pattern and Name.
+type Matcher interface {
+	Match(input []byte) (bool, int, interface{}, []byte)
+}
+
+// ContextMatcher represents token's matcher function type which also gets
+// the previously scanned token or nil, see TokenMatcher.
+type ContextMatcher func(input []byte, prev *Token) (bool, int, interface{}, []byte)
+
+// matcherMeta contains additional matcher's info.
+type matcherMeta struct {
+	priority int            // see AddMatcherWithPriority
+	context  ContextMatcher // see AddContextMatcher
+	group    string         // see AddMatcherToGroup
+}
+
+// NamedSource represents a named part of the input, see NewLexerMulti.
+type NamedSource struct {
+	Name string // source name, e.g. file name
+	Text string // source text
+}
+
+// sourceSpan is the named source's position in the input.
+type sourceSpan struct {
+	name  string // source name
+	start int    // offset of the source start in the input
+}
+
+// stopName is the token name wrapper which finishes scanning after
+// the token, see StopMatcher.
+type stopName struct {
+	name interface{}
+}
+
+// triviaName is the skip's name wrapper which collects the skipped text as
+// trivia, see CollectIfMatches.
+type triviaName struct {
+	name interface{}
+}
+
+// Rule represents a declarative regexp matcher's definition, see AddRules.
+type Rule struct {
+	Pattern string      // regexp pattern
+	Name    interface{} // token name
+	Skip    bool        // whether the matches should be skipped
+}
+
+// TokenFilter represents token's filter function type. It returns the token
+// to pass further, which may be modified or replaced, and false if the token
+// should be dropped.
+type TokenFilter func(*Token) (*Token, bool)
+
+// NewLexer creates new lexer with given input.
+func NewLexer(text string) *Lexer {
+	l := &Lexer{Input: text, input: []byte(text)}
+	l.Reset()
+	return l
+}
+
+// NewLexerFromBytes creates new lexer with given input bytes. The bytes
+// aren't copied, so they must not be changed during scanning. The Input field
+// of the lexer is left empty.
+//
+//   b, _ := ioutil.ReadFile("source.txt")
+//   l := NewLexerFromBytes(b)
+func NewLexerFromBytes(b []byte) *Lexer {
+	l := &Lexer{input: b}
+	l.Reset()
+	return l
+}
+
+// NewLexerMulti creates new lexer instance of the concatenated sources.
+// The tokens get the Source name and SourceOffset of the source they start in,
+// while Line, Column and Offset are relative to the whole input.
+func NewLexerMulti(sources []NamedSource) *Lexer {
+	var text []byte
+	spans := make([]sourceSpan, 0, len(sources))
+	for _, src := range sources {
+		spans = append(spans, sourceSpan{name: src.Name, start: len(text)})
+		text = append(text, src.Text...)
+	}
+	l := NewLexer(string(text))
+	l.sources = spans
+	return l
+}
+
+// NewLexerWithMatchers creates new lexer with given input and matchers.
+//
+//   text := `text which need to be tokenized`
+//   l := NewLexerWithMatchers(text, []TokenMatchers{
+//     TokenizeIfMatches(`\d+`, "DIGIT"),
+//     SkipIfMatches(`\s+`),
+//   })
+func NewLexerWithMatchers(text string, matchers []TokenMatcher) *Lexer {
+	l := NewLexer(text)
+	for _, m := range matchers {
+		l.AddMatcher(m)
+	}
+	return l
+}
+
+// Tokenize scans the whole text with given matchers and returns the tokens
+// along with the scanning error if any.
+//
+//   tokens, err := Tokenize("1 2", []TokenMatcher{
+//     TokenizeIfMatches(`\d+`, "DIGIT"),
+//     SkipIfMatches(`\s+`),
+//   })
+func Tokenize(text string, matchers []TokenMatcher) ([]*Token, error) {
+	return NewLexerWithMatchers(text, matchers).ScanAll()
+}
+
+// NewToken creates new token with given name and body.
+func NewToken(name interface{}, text []byte) *Token {
+	return &Token{Name: name, Text: text}
+}
+
+// Is checks whether the token has given name and body.
+//
+//   if l.Token().Is("WORD", "foo") {
+//   }
+func (t *Token) Is(name interface{}, text string) bool {
+	return t.Name == name && string(t.Text) == text
+}
+
+// TextString returns the token's body as a string.
+func (t *Token) TextString() string {
+	return string(t.Text)
+}
+
+// Len returns the length of the token's consumed text in bytes, which is
+// the length of Raw or of the body if Raw isn't set, e.g. for the tokens
+// created with NewToken.
+func (t *Token) Len() int {
+	if t.Raw != nil {
+		return len(t.Raw)
+	}
+	return len(t.Text)
+}
+
+// EndOffset returns the byte offset of the token end in the input, so
+// the token spans the [Offset, EndOffset) range.
+func (t *Token) EndOffset() int {
+	return t.Offset + t.Len()
+}
+
+// Release returns the token to the pool it's taken from, see
+// Lexer.SetTokenPool. The token must not be used after the release.
+// It does nothing for the tokens which aren't taken from a pool.
+func (t *Token) Release() {
+	if p := t.pool; p != nil {
+		*t = Token{}
+		p.Put(t)
+	}
+}
+
+// String returns the readable token representation like `WORD("foo")`.
+func (t *Token) String() string {
+	if t.stringer != nil {
+		return fmt.Sprintf("%s(%q)", t.stringer(t.Name), t.Text)
+	}
+	return fmt.Sprintf("%v(%q)", t.Name, t.Text)
+}
+
+// String returns the short lexer summary with the number of matchers and
+// the current position.
+func (l *Lexer) String() string {
+	return fmt.Sprintf("Lexer(matchers: %d, pos: %d/%d)", len(l.matchers), l.Pos(), len(l.input))
+}
+
+// AddMatcher adds new matter to end of the matchers list. It returns the lexer
+// itself, so the calls can be chained.
+//
+//   l := NewLexer(`some text`)
+//   l.AddMatcher(TokenizeIfMatches(`\d+`, "DIGIT"))
+//   l.AddMatcher(SkipIfMatches(`\s+`))
+//
+//   // or
+//   l := NewLexer(`some text`).
+//     AddMatcher(TokenizeIfMatches(`\d+`, "DIGIT")).
+//     AddMatcher(SkipIfMatches(`\s+`))
+func (l *Lexer) AddMatcher(fn TokenMatcher) *Lexer {
+	return l.AddMatcherWithPriority(fn, 0)
+}
+
+// Add adds new matcher to end of the matchers list like AddMatcher, but
+// it accepts any Matcher implementation, e.g. RegexpMatcher or TokenMatcher.
+// The pattern and the token name of RegexpMatcher are kept for Validate,
+// LastPattern and WriteDOT.
+func (l *Lexer) Add(m Matcher) *Lexer {
+	switch m := m.(type) {
+	case TokenMatcher:
+		return l.AddMatcher(m)
+	case *RegexpMatcher:
+		return l.AddMatcher(m.fn)
+	}
+	return l.AddMatcher(m.Match)
+}
+
+// AddRules adds the matchers of given rules to end of the matchers list. It
+// creates RegexpMatcher matchers, the skip rules produce the skips.
+//
+//   l.AddRules([]Rule{
+//     {Pattern: `\s+`, Skip: true},
+//     {Pattern: `\d+`, Name: "DIGIT"},
+//     {Pattern: `\w+`, Name: "WORD"},
+//   })
+func (l *Lexer) AddRules(rules []Rule) *Lexer {
+	for _, rule := range rules {
+		if rule.Skip {
+			l.Add(NewRegexpMatcher(rule.Pattern, nil))
+		} else {
+			l.Add(NewRegexpMatcher(rule.Pattern, rule.Name))
+		}
+	}
+	return l
+}
+
+// AddMatcherWithPriority adds new matcher before all the matchers with lower
+// priority, so the ones with higher priority are tried first regardless of
+// the insertion order. Matchers with the same priority keep the insertion
+// order. Matchers added by AddMatcher have zero priority.
+//
+//   l.AddMatcher(TokenizeIfMatches(`\w+`, "IDENT"))
+//   l.AddMatcherWithPriority(TokenizeIfMatches(`if\b`, "IF"), 10) // goes first
+func (l *Lexer) AddMatcherWithPriority(fn TokenMatcher, priority int) *Lexer {
+	return l.addMatcher(fn, matcherMeta{priority: priority})
+}
+
+// AddMatcherToGroup adds new matcher to end of the matchers list and tags it
+// with the group name, so the matcher may be turned off and on with
+// EnableGroup. The groups are enabled by default.
+//
+//   l.AddMatcherToGroup("shell", SkipIfMatches(`#[^\n]*`))
+//   l.EnableGroup("shell", interactive)
+func (l *Lexer) AddMatcherToGroup(group string, fn TokenMatcher) *Lexer {
+	return l.addMatcher(fn, matcherMeta{group: group})
+}
+
+// EnableGroup enables or disables the matchers of the group, see
+// AddMatcherToGroup. Scan doesn't try the matchers of the disabled groups.
+func (l *Lexer) EnableGroup(group string, on bool) *Lexer {
+	l.lookahead = nil
+	if on {
+		delete(l.disabled, group)
+		return l
+	}
+	if l.disabled == nil {
+		l.disabled = make(map[string]bool)
+	}
+	l.disabled[group] = true
+	return l
+}
+
+// enabled returns false if the matcher with given index belongs to
+// the disabled group.
+func (l *Lexer) enabled(i int) bool {
+	return i >= len(l.meta) || l.meta[i].group == "" || !l.disabled[l.meta[i].group]
+}
+
+// AddContextMatcher adds new context matcher to end of the matchers list.
+// The matcher gets the previous token besides the input, so it can depend on
+// the context, e.g. to decide whether `/` is a division or a regexp start.
+func (l *Lexer) AddContextMatcher(fn ContextMatcher) *Lexer {
+	return l.addMatcher(func(input []byte) (bool, int, interface{}, []byte) {
+		return fn(input, nil)
+	}, matcherMeta{context: fn})
+}
+
+// addMatcher adds new matcher with given meta info before all the matchers
+// with lower priority.
+func (l *Lexer) addMatcher(fn TokenMatcher, meta matcherMeta) *Lexer {
+	l.lookahead = nil
+	for len(l.meta) < len(l.matchers) {
+		l.meta = append(l.meta, matcherMeta{})
+	}
+	i := len(l.matchers)
+	for i > 0 && l.meta[i-1].priority < meta.priority {
+		i--
+	}
+	if i == len(l.matchers) {
+		l.matchers = append(l.matchers, fn)
+		l.meta = append(l.meta, meta)
+		return l
+	}
+	l.insertMatcher(i, fn, meta)
+	return l
+}
+
+// insertMatcher inserts the matcher at given index of the synced matchers
+// and meta lists.
+func (l *Lexer) insertMatcher(i int, fn TokenMatcher, meta matcherMeta) {
+	l.lookahead = nil
+	// build new slices, since the old ones may be shared with clones
+	matchers := make([]TokenMatcher, 0, len(l.matchers)+1)
+	matchers = append(matchers, l.matchers[:i]...)
+	l.matchers = append(append(matchers, fn), l.matchers[i:]...)
+	metas := make([]matcherMeta, 0, len(l.meta)+1)
+	metas = append(metas, l.meta[:i]...)
+	l.meta = append(append(metas, meta), l.meta[i:]...)
+}
+
+// PrependMatcher adds new matcher to the beginning of the matchers list, so
+// it takes precedence over the others, e.g. a keyword over an identifier.
+// It gets the priority of the first matcher. It returns the lexer itself to
+// support chaining.
+func (l *Lexer) PrependMatcher(fn TokenMatcher) *Lexer {
+	l.InsertMatcher(0, fn)
+	return l
+}
+
+// InsertMatcher inserts new matcher at given index of the matchers list,
+// which is between 0 and len(l.Matchers()). It gets the priority of the matcher
+// it's inserted before, or of the last one if it's appended, so the list stays
+// ordered by the priorities.
+func (l *Lexer) InsertMatcher(i int, fn TokenMatcher) error {
+	if i < 0 || i > len(l.matchers) {
+		return errors.New(fmt.Sprintf(insertErrorMessage, i, len(l.matchers)))
+	}
+	for len(l.meta) < len(l.matchers) {
+		l.meta = append(l.meta, matcherMeta{})
+	}
+	var meta matcherMeta
+	if i < len(l.meta) {
+		meta.priority = l.meta[i].priority
+	} else if i > 0 {
+		meta.priority = l.meta[i-1].priority
+	}
+	l.insertMatcher(i, fn, meta)
+	return nil
+}
+
+// Matchers returns a copy of the token matchers list, so changing it doesn't
+// affect the lexer.
+func (l *Lexer) Matchers() []TokenMatcher {
+	return append([]TokenMatcher(nil), l.matchers...)
+}
+
+// Err returns the error of scanning or nil.
+func (l *Lexer) Err() error {
+	return l.Error
+}
+
+// CountMatchers returns the number of the matchers, including the skip
+// matchers added with AddSkip, but not the EOF ones. The matchers' indices,
+// e.g. LastMatcher, are less than this number.
+func (l *Lexer) CountMatchers() int {
+	return len(l.matchers) + len(l.skips)
+}
+
+// AddSkip adds new skip matcher, e.g. SkipIfMatches, separately from
+// the token matchers. The skip matchers are tried after the token matchers
+// or before them if SkipFirst is set. The skip matchers' indices follow
+// the token matchers' ones, so the first skip matcher's index is
+// len(l.Matchers()). It returns the lexer itself to support chaining.
+func (l *Lexer) AddSkip(fn TokenMatcher) *Lexer {
+	l.skips = append(l.skips, fn)
+	l.lookahead = nil
+	return l
+}
+
+// AddFilter adds new filter to end of the filters list. Each scanned token
+// is passed through the filters in order before it's returned by Scan.
+//
+//   // drop all the comments
+//   l.AddFilter(func(t *Token) (*Token, bool) {
+//     return t, t.Name != "COMMENT"
+//   })
+func (l *Lexer) AddFilter(fn TokenFilter) *Lexer {
+	l.filters = append(l.filters, fn)
+	return l
+}
+
+// AddEOFMatcher adds new matcher which runs at the end of input, so it can
+// produce a final synthetic token, e.g. to close an implicit block. After the
+// whole input is consumed each EOF matcher is called exactly once with empty
+// input, in the order they were added, and the matched ones produce tokens.
+//
+//   l.AddEOFMatcher(func(input []byte) (bool, int, interface{}, []byte) {
+//     return true, 0, "END", nil
+//   })
+func (l *Lexer) AddEOFMatcher(fn TokenMatcher) *Lexer {
+	l.eofMatchers = append(l.eofMatchers, fn)
+	return l
+}
+
+// EnableIndentation makes Scan to produce the tokens of the indentation
+// changes for Python-like grammars. The indentation of a line is the column
+// of its first token, so the leading whitespace is skipped by the matchers
+// as usual and the blank lines don't change it. When a line is indented
+// deeper than the current level, an indentName token is produced, and when
+// it's indented less, a dedentName token is produced per closed level.
+// The remaining levels are closed at the end of input, before the tokens of
+// the EOF matchers if any. The tokens are
+// produced right before the line's first token at its position, they have
+// empty body and aren't passed through the filters.
+//
+//   l.EnableIndentation("INDENT", "DEDENT")
+func (l *Lexer) EnableIndentation(indentName, dedentName interface{}) *Lexer {
+	l.indentName, l.dedentName = indentName, dedentName
+	l.indentation = true
+	return l
+}
+
+// indent pushes back the token with the indentation tokens before it if
+// it's the first token of a line, or the remaining dedentation tokens at
+// the end of input when the token is nil. It returns whether any
+// indentation tokens are pushed.
+func (l *Lexer) indent(token *Token) bool {
+	width := 0
+	line, column, offset := l.line, l.column, l.Pos()
+	if token != nil {
+		// the EOF matchers' tokens close all the levels wherever they are
+		if token.Line == l.indentLine && token.Kind != TokenKindEOF {
+			return false
+		}
+		l.indentLine = token.Line
+		if token.Kind != TokenKindEOF {
+			width = token.Column - 1
+		}
+		line, column, offset = token.Line, token.Column, token.Offset
+	}
+
+	var tokens []*Token
+	if n := len(l.indents); width > 0 && (n == 0 || l.indents[n-1] < width) {
+		l.indents = append(l.indents, width)
+		tokens = append(tokens, l.newToken(l.indentName, nil))
+	}
+	for n := len(l.indents); n > 0 && l.indents[n-1] > width; n-- {
+		l.indents = l.indents[:n-1]
+		tokens = append(tokens, l.newToken(l.dedentName, nil))
+	}
+	if len(tokens) == 0 {
+		return false
+	}
+	if token != nil {
+		if token == l.into {
+			// the caller's token of ScanInto is overwritten with the first
+			// indentation token, so the copy is pushed back
+			copied := *token
+			token = &copied
+		}
+		token.matcher = l.LastMatcher + 1
+		l.pushback = append(l.pushback, token)
+	}
+	for i := len(tokens) - 1; i >= 0; i-- {
+		l.place(tokens[i], line, column, offset)
+		l.pushback = append(l.pushback, l.detach(tokens[i]))
+	}
+	return true
+}
+
+// AddMergeRule makes Scan to merge the adjacent tokens with given name into
+// a single one. The merged token's body is the concatenation of the bodies
+// and its other info is taken from the first token. The name must be
+// a valid map key, the tokens with the names of the types which can't be
+// map keys, like slices, are never merged.
+//
+//   l.AddMatcher(TokenizeIfMatches(`.`, "CHAR"))
+//   l.AddMergeRule("CHAR") // `abc` gives a single CHAR token
+func (l *Lexer) AddMergeRule(name interface{}) *Lexer {
+	if l.merges == nil {
+		l.merges = make(map[interface{}]bool)
+	}
+	l.merges[name] = true
+	return l
+}
+
+// mergeable returns whether the tokens with given name are merged, see
+// AddMergeRule.
+func (l *Lexer) mergeable(name interface{}) bool {
+	if len(l.merges) == 0 {
+		return false
+	}
+	if t := reflect.TypeOf(name); t != nil && !t.Comparable() {
+		return false
+	}
+	return l.merges[name]
+}
+
+// SetTokenPool makes Scan to take the tokens from the pool instead of
+// allocating them, so the scanned tokens can be reused with Token.Release.
+// The lexer refers to the current token, so a token may be released only
+// after the next Scan call returns or when scanning is finished.
+// The tokens supplied by matchers aren't taken from the pool.
+func (l *Lexer) SetTokenPool(p *sync.Pool) *Lexer {
+	l.pool = p
+	return l
+}
+
+// SetTrace sets the hook which is called with the result of every matcher
+// tried by Scan, so it's possible to see why a rule loses to an earlier one.
+// The nil hook disables tracing.
+//
+//   l.SetTrace(func(i int, matched bool, shift int) {
+//     log.Printf("matcher %d: %v %d", i, matched, shift)
+//   })
+func (l *Lexer) SetTrace(fn func(matcherIndex int, matched bool, shift int)) *Lexer {
+	l.trace = fn
+	return l
+}
+
+// SetErrorFormatter sets the function producing the error when no matcher
+// matches the input, it's called with the offset and the rest of the input.
+// The nil function restores the default error message.
+//
+//   l.SetErrorFormatter(func(offset int, remaining []byte) error {
+//     return &SyntaxError{Offset: offset}
+//   })
+func (l *Lexer) SetErrorFormatter(fn func(offset int, remaining []byte) error) *Lexer {
+	l.errorFormatter = fn
+	return l
+}
+
+// SetNameStringer sets the function rendering the tokens' names, which is
+// used by Token.String and the Write methods, e.g. to print the names of
+// integer constants. The nil function restores the default formatting.
+//
+//   l.SetNameStringer(func(name interface{}) string {
+//     return tokenNames[name.(int)]
+//   })
+func (l *Lexer) SetNameStringer(fn func(interface{}) string) *Lexer {
+	l.nameStringer = fn
+	return l
+}
+
+// nameString renders the token name, see SetNameStringer.
+func (l *Lexer) nameString(name interface{}) string {
+	if l.nameStringer != nil {
+		return l.nameStringer(name)
+	}
+	return fmt.Sprint(name)
+}
+
+// SetTieBreaker sets the function which reports whether the candidate a
+// is preferred to b when both consume the same number of bytes in
+// the LongestMatch mode. The matcher added earlier wins by default.
+//
+//   l.SetTieBreaker(func(a, b MatchCandidate) bool {
+//     return a.Name == "KEYWORD" // keywords win over identifiers
+//   })
+func (l *Lexer) SetTieBreaker(cmp func(a, b MatchCandidate) bool) *Lexer {
+	l.tieBreaker = cmp
+	return l
+}
+
+// Scan scans for a new token. It returns false if can't find any new token.
+// The matchers aren't called at the end of input, only the EOF matchers are.
+// Once it returns false because of the end of input or an error, the next
+// calls return false as well without scanning, until the lexer is reset.
+func (l *Lexer) Scan() bool {
+	if l.currentToken != nil {
+		l.prevToken = l.currentToken
+	}
+	l.currentToken = nil
+	l.LastMatcher = -1
+	if n := len(l.pushback); n > 0 {
+		l.currentToken, l.pushback = l.pushback[n-1], l.pushback[:n-1]
+		l.LastMatcher, l.currentToken.matcher = l.currentToken.matcher-1, 0
+		return true
+	}
+	if l.done {
+		return false
+	}
+	if !l.scan() {
+		if l.indentation && l.Error == nil && l.indent(nil) {
+			return l.Scan()
+		}
+		l.done = true
+		return false
+	}
+	if l.indentation && l.indent(l.currentToken) {
+		l.currentToken = nil
+		return l.Scan()
+	}
+	return true
+}
+
+// ScanInto is the same as Scan, but it fills given token instead of
+// allocating a new one, so a single token may be reused in a tight loop.
+// The token becomes the current one and its text still refers to the input
+// unless CopyTokenText is set. The tokens supplied by matchers and
+// the pushed back ones are copied into it.
+//
+//   var token lexer.Token
+//   for l.ScanInto(&token) {
+//     ...
+//   }
+func (l *Lexer) ScanInto(tok *Token) bool {
+	l.into = tok
+	ok := l.Scan()
+	l.into = nil
+	if ok && l.currentToken != tok {
+		*tok = *l.currentToken
+	}
+	return ok
+}
+
+// scan scans for a new token skipping the filtered out ones. It loops
+// instead of recursing, so long runs of the skips and the filtered out
+// tokens don't overflow the stack.
+func (l *Lexer) scan() bool {
+	for {
+		if l.SkipBOM && l.Pos() == 0 && bytes.HasPrefix(l.currentInput, utf8BOM) {
+			l.currentInput = l.currentInput[len(utf8BOM):]
+		}
+		if l.MaxInputLen > 0 && len(l.input) > l.MaxInputLen {
+			l.Error = errors.New(fmt.Sprintf(inputTooLongErrorMessage, len(l.input), l.MaxInputLen))
+			return false
+		}
+		if len(l.currentInput) == 0 {
+			// the matchers aren't called with the empty input
+			return l.scanEOF()
+		}
+		if l.StrictAmbiguity {
+			if indices := l.consuming(); len(indices) > 1 {
+				l.Error = errors.New(fmt.Sprintf(ambiguityErrorMessage, indices, string(l.currentInput)))
+				return false
+			}
+		}
+
+		var raw []byte
+		line, column, offset := l.line, l.column, l.Pos()
+		index, matched, shift, tokenName, tokenText := l.nextMatch()
+		if err, ok := tokenName.(error); ok {
+			l.Error = err
+			return false
+		}
+		if shift > len(l.currentInput) {
+			l.Error = errors.New(fmt.Sprintf(overshiftErrorMessage, index, shift, len(l.currentInput), line, column))
+			return false
+		}
+		if l.StrictAnchors && (matched || shift > 0) {
+			if offset := textOffset(l.currentInput, tokenText); offset > 0 && offset+len(tokenText) > shift {
+				l.Error = &UnanchoredMatchError{Matcher: index, Pos: l.Pos(), Offset: offset}
+				return false
+			}
+		}
+		if shift > 0 {
+			raw = l.currentInput[:shift]
+			_, trivia := tokenName.(triviaName)
+			if l.MaxTokenLen > 0 && shift > l.MaxTokenLen && (matched || trivia) {
+				l.Error = errors.New(fmt.Sprintf(tokenTooLongErrorMessage, shift, l.MaxTokenLen, string(raw[:l.MaxTokenLen])))
+				return false
+			}
+			l.advance(shift)
+		}
+
+		switch {
+		case matched && shift > 0:
+			// a token, which may finish scanning, see StopMatcher
+			stop, ok := tokenName.(stopName)
+			if ok {
+				tokenName = stop.name
+			}
+			var token *Token
+			if _, whole := tokenName.(*Token); whole || l.into == nil {
+				token = l.matchedToken(tokenName, tokenText, raw)
+			} else {
+				token = l.into
+				*token = Token{Name: tokenName, Text: tokenText, Raw: raw}
+			}
+			l.place(token, line, column, offset)
+			token.LeadingSkip, l.skipped = l.skipped, 0
+			if l.mergeable(token.Name) && !l.merge(token) {
+				return false
+			}
+			if !l.emit(token, index) {
+				if ok || l.Error != nil {
+					return false
+				}
+				// the token is dropped by a filter
+				continue
+			}
+			if ok {
+				l.done = true
+			}
+			return true
+		case matched:
+			// an empty token, which would be produced at the same position forever
+			l.Error = errors.New(fmt.Sprintf(emptyTokenErrorMessage, index, line, column))
+			return false
+		case shift > 0:
+			// a skip, which is collected as trivia, see CollectIfMatches
+			if trivia, ok := tokenName.(triviaName); ok {
+				token := l.matchedToken(trivia.name, tokenText, raw)
+				token.Kind = TokenKindTrivia
+				l.place(token, line, column, offset)
+				l.trivia = append(l.trivia, l.detach(token))
+			}
+			l.skipped += shift
+			continue
+		}
+
+		// no matcher matches
+		if !l.ErrorRecovery {
+			if l.errorFormatter != nil {
+				l.Error = l.errorFormatter(l.Pos(), l.currentInput)
+			} else {
+				l.Error = errors.New(fmt.Sprintf(cantMatchErrorMessage, l.line, l.column, l.Pos(), l.snippet(l.currentInput)))
+			}
+			return false
+		}
+		_, size := utf8.DecodeRune(l.currentInput)
+		token := l.newToken(ERROR, l.currentInput[:size])
+		token.Raw = token.Text
+		token.Kind = TokenKindError
+		l.place(token, line, column, offset)
+		token.LeadingSkip, l.skipped = l.skipped, 0
+		l.advance(size)
+		if !l.emit(token, -1) {
+			if l.Error != nil {
+				return false
+			}
+			continue
+		}
+		return true
+	}
+}
+
+// ScanRange scans the input[start:end] window only and returns its tokens,
+// e.g. to re-tokenize a changed region. The tokens' positions and offsets are
+// relative to the whole input. The range is scanned by a clone, so the lexer
+// state isn't changed. The EOF matchers aren't run at the end of the range.
+func (l *Lexer) ScanRange(start, end int) ([]*Token, error) {
+	if start < 0 || end < start || end > len(l.input) {
+		return nil, errors.New(fmt.Sprintf(rangeErrorMessage, start, end, len(l.input)))
+	}
+	c := l.Clone()
+	c.input = l.input[:end]
+	c.eofMatchers = nil
+	if err := c.Seek(start); err != nil {
+		return nil, err
+	}
+	var tokens []*Token
+	for c.Scan() {
+		tokens = append(tokens, c.Token())
+	}
+	return tokens, c.Error
+}
+
+// SkipUntil scans and drops the tokens until a token with one of the names
+// is scanned, so it's the current token, for the panic-mode error recovery.
+// A scanning error is cleared and the rune it has stopped at is skipped,
+// so the recovery continues up to the end of input. It returns false if
+// no such token is found.
+//
+//   if !parseStatement(l) {
+//     l.SkipUntil("SEMICOLON")
+//   }
+func (l *Lexer) SkipUntil(names ...interface{}) bool {
+	for {
+		if l.Error != nil {
+			if len(l.currentInput) == 0 {
+				return false
+			}
+			_, size := utf8.DecodeRune(l.currentInput)
+			l.advance(size)
+			l.skipped += size
+			l.Error, l.done = nil, false
+		}
+		if !l.Scan() {
+			if l.Error == nil {
+				return false
+			}
+			continue
+		}
+		for _, name := range names {
+			if l.currentToken.Name == name {
+				return true
+			}
+		}
+	}
+}
+
+// ScanLine resets the lexer to scan the line as the whole input and returns
+// all its tokens, so their positions and offsets are relative to the line.
+// It's useful to lex line-based formats read with bufio.Scanner sharing
+// the same matchers.
+//
+//   for s.Scan() {
+//     tokens, err := l.ScanLine(s.Text())
+//   }
+func (l *Lexer) ScanLine(line string) ([]*Token, error) {
+	l.ResetWith(line)
+	var tokens []*Token
+	for l.Scan() {
+		tokens = append(tokens, l.Token())
+	}
+	return tokens, l.Error
+}
+
+// UnScan pushes the token back onto the stream, so the next Scan returns it
+// without running the matchers. The pushed back tokens are returned in
+// the reverse order (LIFO).
+func (l *Lexer) UnScan(tok *Token) {
+	l.pushback = append(l.pushback, tok)
+}
+
+// scanEOF runs the remaining EOF matchers until one of them produces a token.
+func (l *Lexer) scanEOF() bool {
+	for l.eofNext < len(l.eofMatchers) {
+		fn := l.eofMatchers[l.eofNext]
+		l.eofNext++
+		matched, _, name, text := l.invokeEOF(fn)
+		if err, ok := name.(error); ok {
+			l.Error = err
+			return false
+		}
+		if !matched {
+			continue
+		}
+		token := l.matchedToken(name, text, nil)
+		token.Kind = TokenKindEOF
+		l.place(token, l.line, l.column, l.Pos())
+		token.LeadingSkip, l.skipped = l.skipped, 0
+		if l.emit(token, -1) {
+			return true
+		} else if l.Error != nil {
+			return false
+		}
+	}
+	return false
+}
+
+// merge appends the adjacent tokens with the same name to the token.
+// The first result which isn't merged is kept for the next scan, so
+// the matchers aren't called twice at the same position. It returns false
+// if the merged token exceeds MaxTokenLen, which sets the error.
+func (l *Lexer) merge(token *Token) bool {
+	// the body is copied once, since it may refer to the input
+	copied := false
+	// the matchers aren't called with the empty input
+	for len(l.currentInput) > 0 {
+		index, matched, shift, name, text := l.match()
+		if !matched || shift == 0 || shift > len(l.currentInput) {
+			l.keep(index, matched, shift, name, text)
+			return true
+		}
+		next := l.matchedToken(name, text, l.currentInput[:shift])
+		if next.Name != token.Name {
+			next.Release()
+			l.keep(index, matched, shift, name, text)
+			return true
+		}
+		if size := len(token.Raw) + shift; l.MaxTokenLen > 0 && size > l.MaxTokenLen {
+			next.Release()
+			l.Error = errors.New(fmt.Sprintf(tokenTooLongErrorMessage, size, l.MaxTokenLen, string(token.Raw[:l.MaxTokenLen])))
+			return false
+		}
+		if !copied {
+			token.Text = append([]byte(nil), token.Text...)
+			copied = true
+		}
+		token.Text = append(token.Text, next.Text...)
+		next.Release()
+		token.Raw = token.Raw[:len(token.Raw)+shift]
+		l.advance(shift)
+	}
+	return true
+}
+
+// keep keeps the matchers' result at the current position for the next
+// scan, see merge. The results of the context matchers aren't kept, since
+// they depend on the previous token, which is changed by then.
+func (l *Lexer) keep(index int, matched bool, shift int, name interface{}, text []byte) {
+	if index >= 0 && index < len(l.meta) && l.meta[index].context != nil {
+		return
+	}
+	l.lookahead = &matchResult{l.currentInput, index, matched, shift, name, text}
+}
+
+// nextMatch returns the result kept for the current position or matches
+// the input.
+func (l *Lexer) nextMatch() (int, bool, int, interface{}, []byte) {
+	if r := l.lookahead; r != nil {
+		l.lookahead = nil
+		if len(r.input) == len(l.currentInput) && len(r.input) > 0 && &r.input[0] == &l.currentInput[0] {
+			return r.index, r.matched, r.shift, r.name, r.text
+		}
+	}
+	return l.match()
+}
+
+// snippet returns the beginning of the text for error messages, which is
+// truncated to ErrorSnippetLen bytes at the rune boundary.
+func (l *Lexer) snippet(text []byte) string {
+	n := l.ErrorSnippetLen
+	if n <= 0 {
+		n = errorSnippetLength
+	}
+	if len(text) <= n {
+		return string(text)
+	}
+	for n > 0 && !utf8.RuneStart(text[n]) {
+		n--
+	}
+	return string(text[:n]) + "..."
+}
+
+// place sets the token's position and source.
+func (l *Lexer) place(token *Token, line, column, offset int) {
+	token.Line, token.Column, token.Offset = line, column, offset
+	if len(l.sources) == 0 {
+		return
+	}
+	i := sort.Search(len(l.sources), func(i int) bool {
+		return l.sources[i].start > offset
+	}) - 1
+	if i < 0 {
+		i = 0
+	}
+	token.Source = l.sources[i].name
+	token.SourceOffset = offset - l.sources[i].start
+}
+
+// textOffset returns the offset of the text in the input or -1 if the text
+// doesn't refer to the input.
+func textOffset(input, text []byte) int {
+	if len(text) == 0 {
+		return -1
+	}
+	for i := range input {
+		if &input[i] == &text[0] {
+			return i
+		}
+	}
+	return -1
+}
+
+// advance consumes n bytes of the current input and moves the position.
+func (l *Lexer) advance(n int) {
+	l.move(l.currentInput[:n])
+	l.currentInput = l.currentInput[n:]
+}
+
+// move moves the line and column position over the text.
+func (l *Lexer) move(text []byte) {
+	for _, c := range text {
+		if c == '\r' || c == '\n' {
+			// \r\n is a single line break
+			if c == '\r' || !l.lastCR {
+				l.line++
+				l.column = 1
+			}
+		} else if c == '\t' {
+			l.column = l.tab(l.column)
+		} else if utf8.RuneStart(c) {
+			l.column++
+		}
+		l.lastCR = c == '\r'
+	}
+}
+
+// matchedToken builds the token from the matcher's result.
+func (l *Lexer) matchedToken(name interface{}, text, raw []byte) *Token {
+	token, ok := name.(*Token)
+	if !ok {
+		token = l.newToken(name, text)
+	}
+	token.Raw = raw
+	return token
+}
+
+// newToken creates new token taking it from the pool if it's set.
+func (l *Lexer) newToken(name interface{}, text []byte) *Token {
+	if l.pool == nil {
+		return NewToken(name, text)
+	}
+	token, ok := l.pool.Get().(*Token)
+	if !ok {
+		token = &Token{}
+	}
+	token.Name, token.Text, token.pool = name, text, l.pool
+	return token
+}
+
+// emit passes the token produced by the matcher with given index through
+// the filters and makes it the current one. It returns false if the token is
+// dropped by a filter or the MaxTokens limit is exceeded, which sets
+// the error.
+func (l *Lexer) emit(token *Token, index int) bool {
+	for _, fn := range l.filters {
+		var ok bool
+		if token, ok = fn(token); !ok {
+			return false
+		}
+	}
+	if l.MaxTokens > 0 && l.produced >= l.MaxTokens {
+		l.Error = errors.New(fmt.Sprintf(tokenLimitErrorMessage, l.MaxTokens))
+		return false
+	}
+	l.currentToken = l.detach(token)
+	l.LastMatcher = index
+	l.produced++
+	if l.MaxTokens > 0 {
+		l.emitted = append(l.emitted, token.Offset)
+	}
+	if l.CollectStats {
+		if l.names == nil {
+			l.names = make(map[interface{}]int)
+		}
+		l.names[token.Name]++
+	}
+	return true
+}
+
+// detach copies the token's text if CopyTokenText is set and binds
+// the token to the name stringer.
+func (l *Lexer) detach(token *Token) *Token {
+	token.stringer = l.nameStringer
+	if l.CopyTokenText {
+		token.Text = copyBytes(token.Text)
+		token.Raw = copyBytes(token.Raw)
+		if token.Captures != nil {
+			captures := make([][]byte, len(token.Captures))
+			for i, capture := range token.Captures {
+				captures[i] = copyBytes(capture)
+			}
+			token.Captures = captures
+		}
+	}
+	return token
+}
+
+// copyBytes returns a copy of the bytes.
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append(make([]byte, 0, len(b)), b...)
 }
 
-// Token represents the scanned token info.
-type Token struct {
-	Name interface{} // token name
-	Text []byte      // token body
+// ScanAll scans the input to the end and returns the scanned tokens along
+// with the scanning error if any.
+func (l *Lexer) ScanAll() ([]*Token, error) {
+	var tokens []*Token
+	for l.Scan() {
+		tokens = append(tokens, l.Token())
+	}
+	return tokens, l.Error
 }
 
-// TokenMatcher represents token's matcher function type.
-type TokenMatcher func([]byte) (bool, int, interface{}, []byte)
+// ScanN scans for up to n new tokens. It stops early if Scan returns false
+// and returns the scanned tokens along with the scanning error if any.
+func (l *Lexer) ScanN(n int) ([]*Token, error) {
+	var tokens []*Token
+	for len(tokens) < n && l.Scan() {
+		tokens = append(tokens, l.Token())
+	}
+	return tokens, l.Error
+}
 
-// NewLexer creates new lexer with given input.
-func NewLexer(text string) *Lexer {
-	l := &Lexer{Input: text}
-	l.Reset()
-	return l
+// match returns the result of the first matcher which matches or consumes
+// the current input.
+func (l *Lexer) match() (index int, matched bool, shift int, name interface{}, text []byte) {
+	if l.LongestMatch {
+		return l.matchLongest()
+	}
+	skip := -1
+	var skipShift int
+	var skipName interface{}
+	var skipText []byte
+	n := len(l.matchers) + len(l.skips)
+	for i := 0; i < n; i++ {
+		index = i
+		if l.SkipFirst {
+			index = (i + len(l.matchers)) % n
+		}
+		if !l.enabled(index) {
+			continue
+		}
+		matched, shift, name, text = l.callMatcher(index, l.currentInput)
+		if matched || shift > 0 && !l.PreferTokens {
+			return
+		}
+		if shift > 0 && skip < 0 {
+			// the first skip is used unless a token matches, see PreferTokens
+			skip, skipShift, skipName, skipText = index, shift, name, text
+		}
+	}
+	if skip >= 0 {
+		return skip, false, skipShift, skipName, skipText
+	}
+	return -1, false, 0, nil, nil
 }
 
-// NewLexerWithMatchers creates new lexer with given input and matchers.
-//
-//   text := `text which need to be tokenized`
-//   l := NewLexerWithMatchers(text, []TokenMatchers{
-//     TokenizeIfMatches(`\d+`, "DIGIT"),
-//     SkipIfMatches(`\s+`),
-//   })
-func NewLexerWithMatchers(text string, matchers []TokenMatcher) *Lexer {
-	l := NewLexer(text)
-	for _, m := range matchers {
-		l.AddMatcher(m)
+// matchLongest tries all the matchers and returns the result of the one
+// which consumes the most input, see LongestMatch.
+func (l *Lexer) matchLongest() (int, bool, int, interface{}, []byte) {
+	var best *MatchCandidate
+	n := len(l.matchers) + len(l.skips)
+	for i := 0; i < n; i++ {
+		index := i
+		if l.SkipFirst {
+			index = (i + len(l.matchers)) % n
+		}
+		if !l.enabled(index) {
+			continue
+		}
+		matched, shift, name, text := l.callMatcher(index, l.currentInput)
+		if _, ok := name.(error); ok {
+			return index, matched, shift, name, text
+		}
+		if !matched && shift == 0 {
+			continue
+		}
+		c := MatchCandidate{Matcher: index, Matched: matched, Shift: shift, Name: name, Text: text}
+		switch {
+		case best == nil:
+		case l.PreferTokens && c.Matched != best.Matched:
+			if !c.Matched {
+				continue
+			}
+		case c.Shift < best.Shift:
+			continue
+		case c.Shift == best.Shift && (l.tieBreaker == nil || !l.tieBreaker(c, *best)):
+			continue
+		}
+		best = &c
 	}
-	return l
+	if best == nil {
+		return -1, false, 0, nil, nil
+	}
+	return best.Matcher, best.Matched, best.Shift, best.Name, best.Text
 }
 
-// NewToken creates new token with given name and body.
-func NewToken(name interface{}, text []byte) *Token {
-	return &Token{Name: name, Text: text}
+// callMatcher runs the matcher with given index against the input and
+// counts its statistics.
+func (l *Lexer) callMatcher(i int, input []byte) (matched bool, shift int, name interface{}, text []byte) {
+	matched, shift, name, text = l.invoke(i, input)
+	if l.CollectStats {
+		l.count(i, matched || shift > 0)
+	}
+	if l.trace != nil {
+		l.trace(i, matched, shift)
+	}
+	return
+}
+
+// invoke runs the matcher with given index against the input recovering
+// its panic.
+func (l *Lexer) invoke(i int, input []byte) (matched bool, shift int, name interface{}, text []byte) {
+	if !l.PropagatePanics {
+		defer func() {
+			if r := recover(); r != nil {
+				matched, shift, name, text = true, 0, &MatcherPanicError{Matcher: i, Value: r}, nil
+			}
+		}()
+	}
+	if i >= len(l.matchers) {
+		matched, shift, name, text = l.skips[i-len(l.matchers)](input)
+	} else if i < len(l.meta) && l.meta[i].context != nil {
+		matched, shift, name, text = l.meta[i].context(input, l.prevToken)
+	} else {
+		matched, shift, name, text = l.matchers[i](input)
+	}
+	return
+}
+
+// invokeEOF calls the EOF matcher recovering its panic like invoke.
+func (l *Lexer) invokeEOF(fn TokenMatcher) (matched bool, shift int, name interface{}, text []byte) {
+	if !l.PropagatePanics {
+		defer func() {
+			if r := recover(); r != nil {
+				matched, shift, name, text = true, 0, &MatcherPanicError{Matcher: -1, Value: r}, nil
+			}
+		}()
+	}
+	return fn(l.currentInput)
+}
+
+// count updates the statistics of the matcher with given index.
+func (l *Lexer) count(i int, matched bool) {
+	if l.stats == nil {
+		l.stats = make(map[int]*MatcherStats)
+	}
+	stats, ok := l.stats[i]
+	if !ok {
+		stats = &MatcherStats{}
+		l.stats[i] = stats
+	}
+	stats.Attempts++
+	if matched {
+		stats.Matches++
+	}
+}
+
+// consuming returns indices of all the matchers which consume the current
+// input.
+func (l *Lexer) consuming() (indices []int) {
+	for i := 0; i < len(l.matchers)+len(l.skips); i++ {
+		if !l.enabled(i) {
+			continue
+		}
+		// invoke, so the check isn't counted in the stats and the trace
+		if _, shift, _, _ := l.invoke(i, l.currentInput); shift > 0 {
+			indices = append(indices, i)
+		}
+	}
+	return
 }
 
-// AddMatcher adds new matter to end of the matchers list.
+// Step scans for a new token as Scan does and returns the detailed step info.
+// It's useful for debugging tools which need to visualize each step.
 //
-//   l := NewLexer(`some text`)
-//   l.AddMatcher(TokenizeIfMatches(`\d+`, "DIGIT"))
-//   l.AddMatcher(SkipIfMatches(`\s+`))
-func (l *Lexer) AddMatcher(fn TokenMatcher) {
-	l.Matchers = append(l.Matchers, fn)
+//   for r := l.Step(); r.Ok; r = l.Step() {
+//     fmt.Printf("%d: %s by #%d\n", r.Pos, r.Token.Text, r.Matcher)
+//   }
+func (l *Lexer) Step() StepResult {
+	pos := l.Pos()
+	ok := l.Scan()
+	remaining := l.currentInput
+	if len(remaining) > stepPreviewLength {
+		remaining = remaining[:stepPreviewLength]
+	}
+	return StepResult{
+		Ok:        ok,
+		Token:     l.currentToken,
+		Matcher:   l.LastMatcher,
+		Consumed:  l.Pos() - pos,
+		Pos:       l.Pos(),
+		Remaining: remaining,
+	}
 }
 
-// Scan scans for a new token. It returns false if can't find any new token.
-func (l *Lexer) Scan() bool {
-	var matched bool
-	var tokenName interface{}
-	var tokenText []byte
-	var shift int
+// Validate checks the matchers for obvious problems: no matchers registered,
+// matchers which match the empty string, so can produce empty tokens forever,
+// and matchers with duplicate patterns. The matchers are never called, so
+// only the ones built by the pattern constructors like TokenizeIfMatches or
+// NewRegexpMatcher are checked for the patterns.
+// It returns all the found problems combined in a single error.
+func (l *Lexer) Validate() error {
+	n := len(l.matchers) + len(l.skips)
+	if n == 0 {
+		return errors.New(noMatchersErrorMessage)
+	}
+	var problems []string
+	patterns := make(map[string]int)
+	for i := 0; i < n; i++ {
+		info := l.info(i)
+		if info == nil {
+			continue
+		}
+		pattern := info.re.String()
+		if j, ok := patterns[pattern]; ok {
+			problems = append(problems, fmt.Sprintf(duplicateErrorMessage, j, i, pattern))
+		} else {
+			patterns[pattern] = i
+		}
+		if info.re.Match(nil) {
+			problems = append(problems, fmt.Sprintf(zeroWidthErrorMessage, i))
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
 
-	l.currentToken = nil
-F:
-	for _, fn := range l.Matchers {
-		matched, shift, tokenName, tokenText = fn(l.currentInput)
-		if shift > 0 {
-			l.currentInput = l.currentInput[shift:]
+// LastPattern returns the original pattern of the matcher produced
+// the current token, or an empty string if the matcher isn't built by
+// the pattern constructors like TokenizeIfMatches.
+func (l *Lexer) LastPattern() string {
+	if info := l.info(l.LastMatcher); info != nil {
+		return info.pattern
+	}
+	return ""
+}
+
+// info returns the info of the matcher with given index if it's built by
+// the pattern constructors or nil otherwise, see describe.
+func (l *Lexer) info(i int) *matcherInfo {
+	switch {
+	case i < 0 || i >= len(l.matchers)+len(l.skips):
+		return nil
+	case i >= len(l.matchers):
+		return describedBy(l.skips[i-len(l.matchers)])
+	case i < len(l.meta) && l.meta[i].context != nil:
+		return nil
+	}
+	return describedBy(l.matchers[i])
+}
+
+// NameCounts returns the numbers of the scanned tokens by their names. Like
+// Stats, it's collected during the lexer's lifetime if CollectStats is set.
+func (l *Lexer) NameCounts() map[interface{}]int {
+	names := make(map[interface{}]int, len(l.names))
+	for name, n := range l.names {
+		names[name] = n
+	}
+	return names
+}
+
+// MatchingMatchers returns the indices of all the matchers which match or
+// skip the input at the current token's position, not just the one produced
+// the token. It's useful to find the ambiguous rules without StrictAmbiguity.
+// The matchers are called again, but their statistics aren't counted.
+// It returns nil if there is no current token or it's at the end of input,
+// since the matchers aren't called with the empty input.
+func (l *Lexer) MatchingMatchers() (indices []int) {
+	if l.currentToken == nil || l.currentToken.Offset >= len(l.input) {
+		return nil
+	}
+	input := l.input[l.currentToken.Offset:]
+	for i := 0; i < len(l.matchers)+len(l.skips); i++ {
+		if !l.enabled(i) {
+			continue
 		}
-		if matched || shift > 0 {
-			break F
+		if _, shift, _, _ := l.invoke(i, input); shift > 0 {
+			indices = append(indices, i)
 		}
 	}
+	return
+}
 
-	if matched {
-		l.currentToken = NewToken(tokenName, tokenText)
-		return true
-	} else if shift > 0 {
-		return l.Scan()
-	} else {
-		if len(l.currentInput) > 0 {
-			l.Error = errors.New(fmt.Sprintf(cantMatchErrorMessage, string(l.currentInput)))
+// Trivia returns the tokens collected by CollectIfMatches matchers.
+func (l *Lexer) Trivia() []*Token {
+	return l.trivia
+}
+
+// Stats returns the matchers' statistics by the matchers' indices. It's
+// collected during the lexer's lifetime if CollectStats is set, so it isn't
+// cleared by Reset. It's useful to find the hot matchers and reorder them.
+//
+//   l.CollectStats = true
+//   for l.Scan() {
+//   }
+//   for i, s := range l.Stats() {
+//     fmt.Printf("#%d: %d/%d\n", i, s.Matches, s.Attempts)
+//   }
+func (l *Lexer) Stats() map[int]MatcherStats {
+	stats := make(map[int]MatcherStats, len(l.stats))
+	for i, s := range l.stats {
+		stats[i] = *s
+	}
+	return stats
+}
+
+// Pos returns the current byte offset into the input. It accounts for all the
+// consumed bytes, including the skipped ones.
+func (l *Lexer) Pos() int {
+	return len(l.input) - len(l.currentInput)
+}
+
+// LineOffsets returns the byte offsets of the lines' starts in the consumed
+// part of the input, the first one is 0. The offsets are computed on
+// the call, so scanning doesn't pay for them, and the line of an offset
+// can be found with the binary search:
+//
+//   offsets := l.LineOffsets()
+//   line := sort.Search(len(offsets), func(i int) bool { return offsets[i] > offset })
+func (l *Lexer) LineOffsets() []int {
+	offsets := []int{0}
+	consumed := l.input[:l.Pos()]
+	for i, c := range consumed {
+		switch {
+		case c == '\n' && i > 0 && consumed[i-1] == '\r':
+			// \r\n is a single line break
+			offsets[len(offsets)-1] = i + 1
+		case c == '\r' || c == '\n':
+			offsets = append(offsets, i+1)
 		}
-		return false
 	}
+	return offsets
+}
+
+// Remaining returns the input which isn't consumed yet. It refers to
+// the input, so it must not be modified.
+func (l *Lexer) Remaining() []byte {
+	return l.currentInput
+}
+
+// Progress returns the ratio of the consumed input from 0 to 1, e.g. for
+// a progress bar. It's 1 for the empty input.
+func (l *Lexer) Progress() float64 {
+	if len(l.input) == 0 {
+		return 1
+	}
+	return float64(l.Pos()) / float64(len(l.input))
+}
+
+// Consumed returns the input consumed so far, including the tokens' raw text
+// and the skipped bytes. It refers to the input, so it must not be modified.
+func (l *Lexer) Consumed() []byte {
+	return l.input[:l.Pos()]
+}
+
+// tab returns the column after a tab at given column.
+func (l *Lexer) tab(column int) int {
+	width := l.TabWidth
+	if width < 1 {
+		width = 1
+	}
+	if l.TabStops {
+		return (column-1)/width*width + width + 1
+	}
+	return column + width
+}
+
+// Mark returns the current position, so it's possible to rewind to it using
+// Seek later.
+func (l *Lexer) Mark() int {
+	return l.Pos()
+}
+
+// Seek rewinds the lexer to the position returned by Mark. It clears
+// the current token and the error of scanning, drops the trivia collected
+// after the position and doesn't count the tokens produced after it towards
+// MaxTokens. It fails if the indentation is enabled, unless it seeks to
+// the start, since the indentation levels can't be rewound, use Save and
+// Restore instead.
+//
+//   mark := l.Mark()
+//   if !parseStatement(l) {
+//     l.Seek(mark) // backtrack
+//   }
+func (l *Lexer) Seek(mark int) error {
+	if mark < 0 || mark > len(l.input) {
+		return errors.New(fmt.Sprintf(markErrorMessage, mark, len(l.input)))
+	}
+	if l.indentation && mark > 0 {
+		return errors.New(fmt.Sprintf(seekIndentErrorMessage, mark))
+	}
+	l.currentInput = l.input[mark:]
+	l.line, l.column, l.lastCR = 1, 1, false
+	if l.SkipBOM && mark >= len(utf8BOM) && bytes.HasPrefix(l.input, utf8BOM) {
+		l.move(l.input[len(utf8BOM):mark])
+	} else {
+		l.move(l.input[:mark])
+	}
+	l.skipped = 0
+	l.eofNext = 0
+	l.pushback = nil
+	l.indents, l.indentLine = nil, 0
+	n := sort.Search(len(l.trivia), func(i int) bool {
+		return l.trivia[i].Offset >= mark
+	})
+	// clip the capacity, since the dropped trivia may be still referred to
+	l.trivia = l.trivia[:n:n]
+	if n := sort.SearchInts(l.emitted, mark); n < len(l.emitted) {
+		l.emitted = l.emitted[:n]
+		l.produced = n
+	}
+	l.currentToken = nil
+	l.LastMatcher = -1
+	l.Error = nil
+	l.done = false
+	return nil
+}
+
+// Save returns the snapshot of the current scan state to restore it later
+// with Restore. Unlike Clone, it doesn't create a new lexer, so it's cheaper
+// for backtracking in tight loops.
+//
+//   state := l.Save()
+//   if !parseExpression(l) {
+//     l.Restore(state)
+//   }
+func (l *Lexer) Save() State {
+	return State{
+		currentInput: l.currentInput,
+		line:         l.line,
+		column:       l.column,
+		lastCR:       l.lastCR,
+		skipped:      l.skipped,
+		currentToken: l.currentToken,
+		prevToken:    l.prevToken,
+		lastMatcher:  l.LastMatcher,
+		trivia:       l.trivia[:len(l.trivia):len(l.trivia)],
+		pushback:     append([]*Token(nil), l.pushback...),
+		eofNext:      l.eofNext,
+		indents:      append([]int(nil), l.indents...),
+		indentLine:   l.indentLine,
+		produced:     l.produced,
+		emitted:      l.emitted[:len(l.emitted):len(l.emitted)],
+		done:         l.done,
+		err:          l.Error,
+	}
+}
+
+// Restore restores the scan state saved by Save of the same lexer. The state
+// may be restored several times.
+func (l *Lexer) Restore(s State) {
+	l.currentInput = s.currentInput
+	l.line, l.column, l.lastCR = s.line, s.column, s.lastCR
+	l.skipped = s.skipped
+	l.currentToken, l.prevToken = s.currentToken, s.prevToken
+	l.LastMatcher = s.lastMatcher
+	l.trivia = s.trivia
+	l.pushback = append([]*Token(nil), s.pushback...)
+	l.eofNext = s.eofNext
+	l.indents = append([]int(nil), s.indents...)
+	l.indentLine = s.indentLine
+	l.produced = s.produced
+	l.emitted = s.emitted
+	l.done = s.done
+	l.Error = s.err
+}
+
+// AtEOF checks whether the whole input is consumed. It allows to distinguish
+// the end of input from a stop on unmatched text after Scan returns false.
+func (l *Lexer) AtEOF() bool {
+	return len(l.currentInput) == 0
+}
+
+// Resume clears the scanning error and continues scanning from
+// the position where it stopped, so after fixing the matchers the rest of
+// the input can be scanned without scanning the beginning again.
+//
+//   for !l.Resume() && l.Error != nil {
+//     fixMatchers(l)
+//   }
+func (l *Lexer) Resume() bool {
+	l.Error = nil
+	l.done = false
+	return l.Scan()
+}
+
+// Next scans for a new token and returns it. It returns nil token and nil
+// error at the end of input and nil token with the error if scanning fails.
+//
+//   for {
+//     token, err := l.Next()
+//     if err != nil {
+//       return err
+//     }
+//     if token == nil {
+//       break
+//     }
+//     ...
+//   }
+func (l *Lexer) Next() (*Token, error) {
+	if !l.Scan() {
+		return nil, l.Error
+	}
+	return l.currentToken, nil
 }
 
 // Token returns current mached token.
@@ -112,16 +1962,30 @@ func normalizePattern(pattern string) string {
 
 // SkipIfMatches skips the matches without creating a token.
 // It's useful to skip space and any other charaters which don't need to
-// be tokinized.
+// be tokinized. It panics if the pattern can't be compiled.
 func SkipIfMatches(pattern string) TokenMatcher {
-	return func(input []byte) (matched bool, shift int, name interface{}, text []byte) {
-		re := regexp.MustCompile(normalizePattern(pattern))
+	return skipRegexp(regexp.MustCompile(normalizePattern(pattern)), pattern)
+}
+
+// SkipIfMatchesSafe is the same as SkipIfMatches, but returns an error
+// instead of panicking if the pattern can't be compiled.
+func SkipIfMatchesSafe(pattern string) (TokenMatcher, error) {
+	re, err := regexp.Compile(normalizePattern(pattern))
+	if err != nil {
+		return nil, err
+	}
+	return skipRegexp(re, pattern), nil
+}
+
+// skipRegexp skips the matches of the pattern's compiled regexp.
+func skipRegexp(re *regexp.Regexp, pattern string) TokenMatcher {
+	return describe(func(input []byte) (matched bool, shift int, name interface{}, text []byte) {
 		match := re.Find(input)
 		if match == nil {
 			return
 		}
 		return false, len(match), nil, nil
-	}
+	}, matcherInfo{re: re, pattern: pattern})
 }
 
 // TokenizeIfMatches creates token with given name if pattern matches.
@@ -135,20 +1999,347 @@ func SkipIfMatches(pattern string) TokenMatcher {
 //   // you can use any defined constant as token name
 //   TokenizeIfMatches(`\d+`, DIGIT)
 //
+// It panics if the pattern can't be compiled.
 func TokenizeIfMatches(pattern string, tokenName interface{}) TokenMatcher {
+	return tokenizeRegexp(regexp.MustCompile(normalizePattern(pattern)), pattern, tokenName)
+}
+
+// RegexpMatcher is the Matcher of the pattern which keeps the pattern and
+// the token name, so they're available for introspection.
+type RegexpMatcher struct {
+	info matcherInfo
+	fn   TokenMatcher
+}
+
+// NewRegexpMatcher creates the matcher producing token with given name if
+// pattern matches like TokenizeIfMatches. The nil name makes it a skip
+// like SkipIfMatches. It panics if the pattern can't be compiled.
+//
+//   l.Add(NewRegexpMatcher(`\d+`, "DIGIT"))
+func NewRegexpMatcher(pattern string, tokenName interface{}) *RegexpMatcher {
+	re := regexp.MustCompile(normalizePattern(pattern))
+	m := &RegexpMatcher{info: matcherInfo{re: re, pattern: pattern, name: tokenName}}
+	if tokenName == nil {
+		m.fn = skipRegexp(re, pattern)
+	} else {
+		m.fn = tokenizeRegexp(re, pattern, tokenName)
+	}
+	return m
+}
+
+// Match matches the input, see TokenMatcher.
+func (m *RegexpMatcher) Match(input []byte) (bool, int, interface{}, []byte) {
+	return m.fn(input)
+}
+
+// Pattern returns the matcher's original pattern.
+func (m *RegexpMatcher) Pattern() string {
+	return m.info.pattern
+}
+
+// Name returns the name of the matched tokens, it's nil for the skip.
+func (m *RegexpMatcher) Name() interface{} {
+	return m.info.name
+}
+
+// TokenizeIfMatchesSafe is the same as TokenizeIfMatches, but returns an error
+// instead of panicking if the pattern can't be compiled. It's useful for
+// validation of dynamically built patterns.
+//
+//   m, err := TokenizeIfMatchesSafe(pattern, "WORD")
+//   if err != nil {
+//     return err
+//   }
+//   l.AddMatcher(m)
+func TokenizeIfMatchesSafe(pattern string, tokenName interface{}) (TokenMatcher, error) {
+	re, err := regexp.Compile(normalizePattern(pattern))
+	if err != nil {
+		return nil, err
+	}
+	return tokenizeRegexp(re, pattern, tokenName), nil
+}
+
+// tokenizeRegexp creates token with given name if the pattern's compiled
+// regexp matches.
+func tokenizeRegexp(re *regexp.Regexp, pattern string, tokenName interface{}) TokenMatcher {
+	return describe(func(input []byte) (matched bool, shift int, name interface{}, text []byte) {
+		match := re.Find(input)
+		if match == nil {
+			return
+		}
+		return true, len(match), tokenName, match
+	}, matcherInfo{re: re, pattern: pattern, name: tokenName})
+}
+
+// TokenizeCaptures creates token with given name if pattern matches and
+// keeps the pattern's submatches in the token's Captures, where the first one
+// is the whole match as in regexp's FindSubmatch.
+//
+//   TokenizeCaptures(`(\w+)=(\w+)`, "PAIR") // Captures: "a=b", "a", "b"
+func TokenizeCaptures(pattern string, tokenName interface{}) TokenMatcher {
+	re := regexp.MustCompile(normalizePattern(pattern))
+	return describe(func(input []byte) (matched bool, shift int, name interface{}, text []byte) {
+		match := re.FindSubmatch(input)
+		if match == nil {
+			return
+		}
+		token := NewToken(tokenName, match[0])
+		token.Captures = match
+		return true, len(match[0]), token, match[0]
+	}, matcherInfo{re: re, pattern: pattern, name: tokenName})
+}
+
+// TokenizeWithValue creates token with given name if pattern matches and
+// sets the token's Value to the result of the conversion of the match.
+// A conversion error stops scanning and is set as the lexer's Error.
+//
+//   TokenizeWithValue(`\d+`, "INT", func(b []byte) (interface{}, error) {
+//     return strconv.Atoi(string(b))
+//   })
+func TokenizeWithValue(pattern string, tokenName interface{}, conv func([]byte) (interface{}, error)) TokenMatcher {
+	re := regexp.MustCompile(normalizePattern(pattern))
+	return describe(func(input []byte) (matched bool, shift int, name interface{}, text []byte) {
+		match := re.Find(input)
+		if match == nil {
+			return
+		}
+		value, err := conv(match)
+		if err != nil {
+			return true, len(match), err, match
+		}
+		token := NewToken(tokenName, match)
+		token.Value = value
+		return true, len(match), token, match
+	}, matcherInfo{re: re, pattern: pattern, name: tokenName})
+}
+
+// TokenizeIfMatchesTransform creates token with given name if pattern matches
+// and sets the token's body to the transformed match, while Raw keeps
+// the original one. The transform must not modify the match in place, since
+// it refers to the input.
+//
+//   TokenizeIfMatchesTransform(`\d[\d_]*`, "INT", func(b []byte) []byte {
+//     return bytes.Replace(b, []byte("_"), nil, -1)
+//   })
+func TokenizeIfMatchesTransform(pattern string, tokenName interface{}, transform func([]byte) []byte) TokenMatcher {
+	re := regexp.MustCompile(normalizePattern(pattern))
+	return describe(func(input []byte) (matched bool, shift int, name interface{}, text []byte) {
+		match := re.Find(input)
+		if match == nil {
+			return
+		}
+		return true, len(match), tokenName, transform(match)
+	}, matcherInfo{re: re, pattern: pattern, name: tokenName})
+}
+
+// TokenizeIfMatchesNotFollowedBy creates token with given name if pattern
+// matches and the rest of the input right after the match doesn't match
+// the notFollowing pattern. It makes up for the lack of lookahead in regexp.
+//
+//   TokenizeIfMatchesNotFollowedBy(`let`, `\w`, "LET") // doesn't match "letter"
+//
+// It panics if the patterns can't be compiled.
+func TokenizeIfMatchesNotFollowedBy(pattern, notFollowing string, tokenName interface{}) TokenMatcher {
+	re := regexp.MustCompile(normalizePattern(pattern))
+	next := regexp.MustCompile(normalizePattern(notFollowing))
+	return func(input []byte) (matched bool, shift int, name interface{}, text []byte) {
+		match := re.Find(input)
+		if match == nil || next.Match(input[len(match):]) {
+			return
+		}
+		return true, len(match), tokenName, match
+	}
+}
+
+// TokenizeIfMatchesMax is the same as TokenizeIfMatches, but the match is
+// truncated to maxLen bytes, so the rest of it remains for the next Scan.
+// The pattern is anchored as usual, so the rest is matched again from its
+// start and e.g. `\w+` gives several tokens of a long word. The truncation
+// doesn't respect the rune boundaries.
+//
+//   TokenizeIfMatchesMax(`\w+`, "WORD", 1024)
+func TokenizeIfMatchesMax(pattern string, tokenName interface{}, maxLen int) TokenMatcher {
+	re := regexp.MustCompile(normalizePattern(pattern))
 	return func(input []byte) (matched bool, shift int, name interface{}, text []byte) {
-		re := regexp.MustCompile(normalizePattern(pattern))
 		match := re.Find(input)
 		if match == nil {
 			return
 		}
+		if maxLen > 0 && len(match) > maxLen {
+			match = match[:maxLen]
+		}
 		return true, len(match), tokenName, match
 	}
 }
 
-// Reset resets the current scan results.
+// TokenizeIfMatchesPOSIX is the same as TokenizeIfMatches, but the pattern
+// is compiled with POSIX syntax and leftmost-longest semantics, so the longest
+// alternative is matched instead of the first one.
+//
+//   TokenizeIfMatchesPOSIX(`a|ab`, "A") // matches "ab" of "abc"
+//
+// It panics if the pattern can't be compiled.
+func TokenizeIfMatchesPOSIX(pattern string, tokenName interface{}) TokenMatcher {
+	return tokenizeRegexp(regexp.MustCompilePOSIX(normalizePattern(pattern)), pattern, tokenName)
+}
+
+// SkipIfMatchesPOSIX is the same as SkipIfMatches, but the pattern is
+// compiled with POSIX syntax and leftmost-longest semantics, see
+// TokenizeIfMatchesPOSIX.
+func SkipIfMatchesPOSIX(pattern string) TokenMatcher {
+	return skipRegexp(regexp.MustCompilePOSIX(normalizePattern(pattern)), pattern)
+}
+
+// TokenizeIfMatchesRaw is the same as TokenizeIfMatches, but the pattern is
+// compiled exactly as given without the '^' insertion. It's useful when
+// the insertion breaks the pattern, e.g. for alternations with their own
+// anchors like `^a|^b` or flags groups. The match is accepted only
+// at the current position, but the search for it isn't limited, so
+// an unanchored raw pattern scans the whole remaining input each time.
+// Prefer TokenizeIfMatches unless the pattern must be kept intact.
+//
+// It panics if the pattern can't be compiled.
+func TokenizeIfMatchesRaw(pattern string, tokenName interface{}) TokenMatcher {
+	re := regexp.MustCompile(pattern)
+	return describe(func(input []byte) (matched bool, shift int, name interface{}, text []byte) {
+		loc := re.FindIndex(input)
+		if loc == nil || loc[0] != 0 {
+			return
+		}
+		return true, loc[1], tokenName, input[:loc[1]]
+	}, matcherInfo{re: re, pattern: pattern, name: tokenName})
+}
+
+// SkipIfMatchesRaw is the same as SkipIfMatches, but the pattern is
+// compiled exactly as given, see TokenizeIfMatchesRaw.
+//
+// It panics if the pattern can't be compiled.
+func SkipIfMatchesRaw(pattern string) TokenMatcher {
+	re := regexp.MustCompile(pattern)
+	return describe(func(input []byte) (matched bool, shift int, name interface{}, text []byte) {
+		loc := re.FindIndex(input)
+		if loc == nil || loc[0] != 0 {
+			return
+		}
+		return false, loc[1], nil, nil
+	}, matcherInfo{re: re, pattern: pattern})
+}
+
+// TokenizeIfMatchesFold creates token with given name if pattern matches
+// case-insensitively. It's the same as TokenizeIfMatches with the `(?i)` flag,
+// but the flag doesn't interfere with the '^' insertion.
+//
+//   TokenizeIfMatchesFold(`if`, "IF") // matches "if", "IF", "If"
+func TokenizeIfMatchesFold(pattern string, tokenName interface{}) TokenMatcher {
+	return tokenizeRegexp(regexp.MustCompile("(?i)"+normalizePattern(pattern)), pattern, tokenName)
+}
+
+// Clone returns a copy of the lexer with its own scan state. The copy shares
+// the matchers with the original one, so it's cheap to create. It's useful for
+// speculative parsing: scan the copy and drop it if the attempt fails.
+func (l *Lexer) Clone() *Lexer {
+	c := *l
+	if l.currentToken != nil {
+		token := *l.currentToken
+		c.currentToken = &token
+	}
+	// clip the capacities, so appending to one lexer doesn't overwrite
+	// the other's matchers
+	c.matchers = l.matchers[:len(l.matchers):len(l.matchers)]
+	c.meta = l.meta[:len(l.meta):len(l.meta)]
+	c.skips = l.skips[:len(l.skips):len(l.skips)]
+	c.filters = l.filters[:len(l.filters):len(l.filters)]
+	c.eofMatchers = l.eofMatchers[:len(l.eofMatchers):len(l.eofMatchers)]
+	if l.merges != nil {
+		c.merges = make(map[interface{}]bool, len(l.merges))
+		for name := range l.merges {
+			c.merges[name] = true
+		}
+	}
+	c.trivia = append([]*Token(nil), l.trivia...)
+	c.pushback = append([]*Token(nil), l.pushback...)
+	c.indents = append([]int(nil), l.indents...)
+	c.emitted = l.emitted[:len(l.emitted):len(l.emitted)]
+	if l.disabled != nil {
+		c.disabled = make(map[string]bool, len(l.disabled))
+		for group := range l.disabled {
+			c.disabled[group] = true
+		}
+	}
+	if l.stats != nil {
+		c.stats = make(map[int]*MatcherStats, len(l.stats))
+		for i, s := range l.stats {
+			stats := *s
+			c.stats[i] = &stats
+		}
+	}
+	c.names = l.NameCounts()
+	return &c
+}
+
+// StopMatcher creates a matcher which finishes scanning after the token of
+// the given matcher, so the next Scan returns false without an error and
+// the rest of the input is left untokenized, see Remaining. It's useful to
+// scan a header and pass the body to something else. An error of the given
+// matcher is passed through and fails scanning as usual.
+//
+//   l.AddMatcher(StopMatcher(TokenizeIfMatches(`\n\n`, "HEADER_END")))
+func StopMatcher(fn TokenMatcher) TokenMatcher {
+	return func(input []byte) (matched bool, shift int, name interface{}, text []byte) {
+		matched, shift, name, text = fn(input)
+		if _, isError := name.(error); matched && shift > 0 && !isError {
+			name = stopName{name}
+		}
+		return
+	}
+}
+
+// ResetWith resets the lexer to scan the text as the new input with the same
+// matchers.
+func (l *Lexer) ResetWith(text string) {
+	l.Input, l.input, l.sources = text, []byte(text), nil
+	l.Reset()
+}
+
+// CollectIfMatches creates token with given name if pattern matches, but
+// instead of returning the token from Scan it's added to the lexer's trivia.
+// It's useful to keep comments without feeding them to a parser.
+//
+//   l.AddMatcher(CollectIfMatches(`//[^\n]*`, "COMMENT"))
+//   for l.Scan() {
+//     // no comments here
+//   }
+//   comments := l.Trivia()
+func CollectIfMatches(pattern string, tokenName interface{}) TokenMatcher {
+	re := regexp.MustCompile(normalizePattern(pattern))
+	return describe(func(input []byte) (matched bool, shift int, name interface{}, text []byte) {
+		match := re.Find(input)
+		if match == nil {
+			return
+		}
+		return false, len(match), triviaName{tokenName}, match
+	}, matcherInfo{re: re, pattern: pattern})
+}
+
+// Reset resets the current scan results to scan the input from the beginning.
+// The input is copied at the lexer's creation, so changing the Input field
+// doesn't affect it, use ResetWith to scan another input.
 func (l *Lexer) Reset() {
 	l.Error = nil
-	l.currentInput = []byte(l.Input)
+	l.currentInput = l.input
+	l.line = 1
+	l.column = 1
+	l.lastCR = false
+	l.skipped = 0
+	l.eofNext = 0
 	l.currentToken = nil
+	l.prevToken = nil
+	l.LastMatcher = -1
+	l.produced = 0
+	l.emitted = nil
+	l.trivia = nil
+	l.pushback = nil
+	l.indents, l.indentLine = nil, 0
+	l.lookahead = nil
+	l.done = false
 }
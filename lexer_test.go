@@ -1,8 +1,13 @@
 package lexer_test
 
 import (
+	"bytes"
 	"fmt"
 	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -23,24 +28,263 @@ func TestLexer_NewLexer(t *testing.T) {
 	assert.Equal(l.Input, text)
 }
 
+func TestLexer_NewLexerFromBytes(t *testing.T) {
+	assert := assert.New(t)
+	text := []byte(`foo 1`)
+	l := lexer.NewLexerFromBytes(text)
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+
+	assert.True(l.Scan())
+	assert.Equal([]byte("foo"), l.Token().Text)
+	assert.True(l.Scan())
+	assert.Equal(5, l.Pos())
+	assert.False(l.Scan())
+
+	l.Reset()
+	assert.Equal(0, l.Pos())
+	assert.True(l.Scan())
+	assert.Equal([]byte("foo"), l.Token().Text, "Should restore the original bytes")
+}
+
 func TestLexer_NewLexerWithMatchers(t *testing.T) {
 	assert := assert.New(t)
 	l := lexer.NewLexerWithMatchers(`foo`, []lexer.TokenMatcher{
 		lexer.TokenizeIfMatches(`^foo`, "FOO"),
 	})
 
-	assert.Equal(len(l.Matchers), 1)
+	assert.Equal(len(l.Matchers()), 1)
+}
+
+func TestTokenize(t *testing.T) {
+	assert := assert.New(t)
+	matchers := []lexer.TokenMatcher{
+		lexer.TokenizeIfMatches(`\d+`, "DIGIT"),
+		lexer.SkipIfMatches(`\s+`),
+	}
+
+	tokens, err := lexer.Tokenize("1 23", matchers)
+	assert.NoError(err)
+	assert.Equal(2, len(tokens))
+	assert.Equal("23", string(tokens[1].Text))
+
+	tokens, err = lexer.Tokenize("1 a", matchers)
+	assert.Error(err)
+	assert.Equal(1, len(tokens), "Should return the tokens scanned before the error")
+}
+
+func TestLexer_ScanAll(t *testing.T) {
+	assert := assert.New(t)
+	l := lexer.NewLexer("foo bar")
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	l.AddSkip(lexer.SkipIfMatches(`\s+`))
+
+	tokens, err := l.ScanAll()
+	assert.NoError(err)
+	assert.Equal(2, len(tokens))
+	assert.Equal("bar", string(tokens[1].Text))
+	assert.True(l.AtEOF())
+}
+
+func TestToken_String(t *testing.T) {
+	assert := assert.New(t)
+	token := lexer.NewToken("WORD", []byte("foo\n"))
+
+	assert.Equal(`WORD("foo\n")`, token.String())
+	assert.Equal(`WORD("foo\n")`, fmt.Sprintf("%v", token))
+}
+
+func TestLexer_String(t *testing.T) {
+	assert := assert.New(t)
+	l := lexer.NewLexer(`foo bar`)
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	assert.True(l.Scan())
+
+	assert.Equal(`Lexer(matchers: 1, pos: 3/7)`, l.String())
 }
 
 func TestLexer_AddMatcher(t *testing.T) {
 	assert := assert.New(t)
 	l := lexer.NewLexer(`foo`)
-	assert.Equal(len(l.Matchers), 0, "The matchers list should be empty")
+	assert.Equal(len(l.Matchers()), 0, "The matchers list should be empty")
 	fn := func([]byte) (bool, int, interface{}, []byte) {
 		return true, 0, nil, []byte{}
 	}
 	l.AddMatcher(fn)
-	assert.Equal(len(l.Matchers), 1, "Should increment matchers size by 1")
+	assert.Equal(len(l.Matchers()), 1, "Should increment matchers size by 1")
+}
+
+func TestLexer_Add(t *testing.T) {
+	assert := assert.New(t)
+
+	digits := lexer.NewRegexpMatcher(`\d+`, "DIGIT")
+	assert.Equal(`\d+`, digits.Pattern())
+	assert.Equal("DIGIT", digits.Name())
+
+	word := lexer.TokenizeIfMatches(`[a-z]+`, "WORD")
+	assert.Equal(`[a-z]+`, word.Pattern(), "Should describe the built-in matchers")
+	assert.Equal("WORD", word.Name())
+	assert.Equal(`\s+`, lexer.SkipIfMatches(`\s+`).Pattern())
+	assert.Nil(lexer.SkipIfMatches(`\s+`).Name())
+	assert.Equal("", lexer.TokenizeAnyRune("ANY").Pattern())
+	assert.Nil(lexer.TokenizeAnyRune("ANY").Name())
+
+	l := lexer.NewLexer("12 ab")
+	l.Add(digits).
+		Add(lexer.NewRegexpMatcher(`\s+`, nil)).
+		Add(lexer.TokenizeIfMatches(`[a-z]+`, "WORD"))
+
+	tokens, err := l.ScanAll()
+	assert.NoError(err)
+	assert.Equal(2, len(tokens))
+	assert.Equal("DIGIT", tokens[0].Name)
+	assert.Equal("WORD", tokens[1].Name)
+
+	l.Reset()
+	l.Scan()
+	assert.Equal(`\d+`, l.LastPattern(), "Should keep the pattern for introspection")
+}
+
+func TestLexer_AddMatcherChain(t *testing.T) {
+	assert := assert.New(t)
+	l := lexer.NewLexer(`foo 1`).
+		AddMatcher(lexer.TokenizeIfMatches(`[a-z]+`, "WORD")).
+		AddMatcher(lexer.SkipIfMatches(`\s+`)).
+		AddMatcher(lexer.TokenizeIfMatches(`\d+`, "DIGIT"))
+
+	assert.Equal(3, len(l.Matchers()))
+	assert.True(l.Scan())
+	assert.True(l.Scan())
+	assert.Equal("DIGIT", l.Token().Name)
+}
+
+func TestLexer_AddRules(t *testing.T) {
+	assert := assert.New(t)
+	l := lexer.NewLexer(`foo 12`)
+	l.AddRules([]lexer.Rule{
+		{Pattern: `\s+`, Skip: true},
+		{Pattern: `\d+`, Name: "DIGIT"},
+		{Pattern: `\w+`, Name: "WORD"},
+	})
+
+	assert.Equal(3, len(l.Matchers()))
+	for _, token := range [][]string{{"foo", "WORD"}, {"12", "DIGIT"}} {
+		assert.True(l.Scan())
+		assert.Equal(token[1], l.Token().Name)
+		assert.Equal([]byte(token[0]), l.Token().Text)
+	}
+	assert.False(l.Scan())
+	assert.NoError(l.Error)
+}
+
+func TestLexer_AddMatcherWithPriority(t *testing.T) {
+	assert := assert.New(t)
+	l := lexer.NewLexer(`if iffy 12`)
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "IDENT"))
+	l.AddMatcherWithPriority(lexer.SkipIfMatches(`\s+`), -1)
+	l.AddMatcherWithPriority(lexer.TokenizeIfMatches(`if\b`, "IF"), 10)
+	l.AddMatcherWithPriority(lexer.TokenizeIfMatches(`\d+`, "DIGIT"), 10)
+	l.AddMatcherWithPriority(lexer.TokenizeIfMatches(`.`, "ANY"), -2)
+
+	assert.Equal(5, len(l.Matchers()))
+	for _, token := range [][]string{{"if", "IF"}, {"iffy", "IDENT"}, {"12", "DIGIT"}} {
+		assert.True(l.Scan())
+		assert.Equal(token[1], l.Token().Name)
+		assert.Equal([]byte(token[0]), l.Token().Text)
+	}
+	assert.Equal(1, l.LastMatcher, "Should keep the insertion order for the same priority")
+	assert.False(l.Scan())
+}
+
+func TestLexer_AddContextMatcher(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`a / b = /c/`)
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "IDENT"))
+	l.AddMatcher(lexer.TokenizeIfMatches(`=`, "ASSIGN"))
+	l.AddContextMatcher(func(input []byte, prev *lexer.Token) (bool, int, interface{}, []byte) {
+		if len(input) == 0 || input[0] != '/' {
+			return false, 0, nil, nil
+		}
+		if prev != nil && prev.Name == "IDENT" {
+			return true, 1, "DIV", input[:1]
+		}
+		re := regexp.MustCompile(`^/[^/]*/`)
+		match := re.Find(input)
+		return match != nil, len(match), "REGEXP", match
+	})
+
+	for _, token := range [][]string{
+		{"a", "IDENT"},
+		{"/", "DIV"},
+		{"b", "IDENT"},
+		{"=", "ASSIGN"},
+		{"/c/", "REGEXP"},
+	} {
+		assert.True(l.Scan())
+		assert.Equal(token[1], l.Token().Name)
+		assert.Equal([]byte(token[0]), l.Token().Text)
+	}
+	assert.False(l.Scan())
+	assert.NoError(l.Error)
+
+	l = lexer.NewLexer(`/c/`)
+	l.AddContextMatcher(func(input []byte, prev *lexer.Token) (bool, int, interface{}, []byte) {
+		return prev == nil, len(input), "FIRST", input
+	})
+	assert.True(l.Scan())
+	assert.Equal("FIRST", l.Token().Name, "Should not have the previous token")
+}
+
+func TestLexer_EnableGroup(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer("a #b")
+	l.AddMatcherToGroup("shell", lexer.TokenizeIfMatches(`#[^\n]*`, "COMMENT"))
+	l.AddMatcher(lexer.TokenizeIfMatches(`[a-z#]+`, "WORD"))
+	l.AddSkip(lexer.SkipIfMatches(`\s+`))
+
+	tokens, err := l.ScanN(10)
+	assert.NoError(err)
+	assert.Equal("COMMENT", tokens[1].Name, "Should enable the group by default")
+
+	l.Reset()
+	l.EnableGroup("shell", false)
+	tokens, err = l.ScanN(10)
+	assert.NoError(err)
+	assert.Equal("WORD", tokens[1].Name, "Should not try the disabled matchers")
+	assert.Equal("#b", string(tokens[1].Text))
+
+	l.Reset()
+	l.EnableGroup("shell", true)
+	tokens, err = l.ScanN(10)
+	assert.NoError(err)
+	assert.Equal("COMMENT", tokens[1].Name)
+}
+
+func TestLexer_AddMergeRule(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`ab{{x}}cd e`)
+	l.AddMatcher(lexer.TokenizeIfMatches(`\{\{\w+\}\}`, "VAR"))
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.TokenizeIfMatches(`.`, "CHAR"))
+	l.AddMergeRule("CHAR")
+
+	for _, token := range [][]string{
+		{"ab", "CHAR"},
+		{"{{x}}", "VAR"},
+		{"cd", "CHAR"},
+		{"e", "CHAR"},
+	} {
+		assert.True(l.Scan())
+		assert.Equal(token[1], l.Token().Name)
+		assert.Equal([]byte(token[0]), l.Token().Text)
+		assert.Equal([]byte(token[0]), l.Token().Raw)
+	}
+	assert.False(l.Scan())
+	assert.NoError(l.Error)
 }
 
 func TestLexer_Scan(t *testing.T) {
@@ -103,6 +347,196 @@ func RunTableTests(t *testing.T, data testData) {
 	})
 }
 
+func TestLexer_MergeLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer("abcdef")
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w`, "CHAR"))
+	l.AddMergeRule("CHAR")
+	l.MaxTokenLen = 3
+	assert.False(l.Scan())
+	assert.EqualError(l.Error, `Token length 4 exceeds the limit of 3 bytes for the following text: "abc"`)
+
+	calls := 0
+	l = lexer.NewLexer("ab1")
+	l.AddMatcher(lexer.TokenizeIfMatches(`[a-z]`, "CHAR"))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\d`, "DIGIT"))
+	l.AddMergeRule("CHAR")
+	l.SetTrace(func(i int, matched bool, shift int) {
+		calls++
+	})
+	tokens, err := l.ScanAll()
+	assert.NoError(err)
+	assert.Equal(2, len(tokens))
+	assert.Equal("ab", string(tokens[0].Text))
+	assert.Equal("DIGIT", tokens[1].Name)
+	assert.Equal(4, calls, "Should not match the next token twice")
+}
+
+func TestLexer_MergeLong(t *testing.T) {
+	assert := assert.New(t)
+
+	input := strings.Repeat("ab", 40000)
+	l := lexer.NewLexer(input + " c")
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w`, "CHAR"))
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMergeRule("CHAR")
+	l.SetTokenPool(&sync.Pool{})
+
+	assert.True(l.Scan())
+	assert.Equal(input, string(l.Token().Text), "Should keep the bodies of the released tokens")
+	assert.True(l.Scan())
+	assert.Equal("c", string(l.Token().Text))
+}
+
+func TestLexer_MergeUncomparableName(t *testing.T) {
+	assert := assert.New(t)
+
+	name := func(input []byte) (bool, int, interface{}, []byte) {
+		return true, 1, []string{"CHAR"}, input[:1]
+	}
+	l := lexer.NewLexer("ab")
+	l.AddMatcher(name)
+	assert.NotPanics(func() {
+		assert.True(l.Scan())
+	}, "Should not use the names as keys without merge rules")
+
+	l = lexer.NewLexer("ab")
+	l.AddMatcher(name)
+	l.AddMergeRule("CHAR")
+	assert.NotPanics(func() {
+		assert.True(l.Scan())
+	})
+	assert.Equal("a", string(l.Token().Text))
+}
+
+func TestLexer_ScanRaw(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`price $12.4`)
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(func(input []byte) (matched bool, shift int, tokenName interface{}, tokenText []byte) {
+		re := regexp.MustCompile(`^\$(\d+(?:\.\d+))`)
+		match := re.FindSubmatch(input)
+		if match == nil {
+			return
+		}
+		return true, len(match[0]), "PRICE", match[1]
+	})
+
+	assert.True(l.Scan())
+	assert.Equal([]byte("price"), l.Token().Text)
+	assert.Equal([]byte("price"), l.Token().Raw)
+
+	assert.True(l.Scan())
+	assert.Equal([]byte("12.4"), l.Token().Text)
+	assert.Equal([]byte("$12.4"), l.Token().Raw, "Should keep the whole consumed text")
+}
+
+func TestLexer_LastMatcher(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`foo 1`)
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\d+`, "DIGIT"))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	assert.Equal(-1, l.LastMatcher)
+
+	assert.True(l.Scan())
+	assert.Equal(2, l.LastMatcher)
+	assert.True(l.Scan())
+	assert.Equal(1, l.LastMatcher, "Should point to the token matcher, not the skip")
+	assert.False(l.Scan())
+	assert.Equal(-1, l.LastMatcher)
+}
+
+func TestLexer_Clone(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`foo bar 1`)
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.TokenizeIfMatches(`[a-z]+`, "WORD"))
+	assert.True(l.Scan())
+
+	c := l.Clone()
+	assert.Equal(l.Token(), c.Token())
+	assert.Equal(l.Pos(), c.Pos())
+	assert.Equal(len(l.Matchers()), len(c.Matchers()))
+
+	assert.True(c.Scan())
+	assert.Equal([]byte("bar"), c.Token().Text)
+	assert.False(c.Scan())
+	assert.Error(c.Error)
+
+	assert.Equal([]byte("foo"), l.Token().Text, "Should not affect the original")
+	assert.Equal(3, l.Pos())
+	assert.NoError(l.Error)
+	assert.True(l.Scan())
+	assert.Equal([]byte("bar"), l.Token().Text)
+}
+
+func TestLexer_CloneAppend(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer("a1a")
+	l.AddMatcher(lexer.TokenizeIfMatches(`\d`, "DIGIT"))
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.TokenizeIfMatches(`-`, "DASH"))
+	l.AddSkip(lexer.SkipIfMatches(`;`))
+	l.AddFilter(func(t *lexer.Token) (*lexer.Token, bool) { return t, true })
+
+	c := l.Clone()
+	l.AddMatcher(lexer.TokenizeIfMatches(`a`, "A")).AddSkip(lexer.SkipIfMatches(`,`))
+	c.AddMatcher(lexer.TokenizeIfMatches(`a`, "A_CLONE")).AddSkip(lexer.SkipIfMatches(`\.`))
+	c.AddMergeRule("A_CLONE")
+
+	tokens, err := l.ScanAll()
+	assert.NoError(err)
+	assert.Equal(3, len(tokens))
+	assert.Equal("A", tokens[0].Name, "Should not share the matchers with the clone")
+	assert.Equal("A", tokens[2].Name)
+
+	c.Reset()
+	tokens, err = c.ScanAll()
+	assert.NoError(err)
+	assert.Equal("A_CLONE", tokens[0].Name)
+	assert.Equal(4, len(l.Matchers()))
+	assert.Equal(4, len(c.Matchers()))
+
+	l = lexer.NewLexer("aa")
+	l.AddMatcher(lexer.TokenizeIfMatches(`a`, "A"))
+	c = l.Clone()
+	c.AddMergeRule("A")
+	tokens, _ = l.ScanAll()
+	assert.Equal(2, len(tokens), "Should not share the merge rules with the clone")
+}
+
+func TestCollectIfMatches(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer("foo // first\nbar // second")
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.CollectIfMatches(`//[^\n]*`, "COMMENT"))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+
+	assert.True(l.Scan())
+	assert.Equal([]byte("foo"), l.Token().Text)
+	assert.True(l.Scan())
+	assert.Equal([]byte("bar"), l.Token().Text, "Should not return the comment")
+	assert.False(l.Scan())
+	assert.NoError(l.Error)
+
+	trivia := l.Trivia()
+	assert.Equal(2, len(trivia))
+	assert.Equal("COMMENT", trivia[0].Name)
+	assert.Equal([]byte("// first"), trivia[0].Text)
+	assert.Equal([]byte("// second"), trivia[1].Text)
+
+	l.Reset()
+	assert.Empty(l.Trivia(), "Trivia should be reseted")
+}
+
 func TestLexer_ScanWithError(t *testing.T) {
 	assert := assert.New(t)
 
@@ -123,45 +557,1960 @@ func TestLexer_ScanWithError(t *testing.T) {
 	assert.NoError(l.Error, "Error should be reseted")
 }
 
-// Simple usage example.
-func ExampleNewLexer() {
-	text := `price 12`
-	l := lexer.NewLexer(text)
+func TestLexer_Pos(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`foo 123  `)
 	l.AddMatcher(lexer.TokenizeIfMatches(`[a-z]+`, "WORD"))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\d+`, "DIGIT"))
 	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
-	l.AddMatcher(lexer.TokenizeIfMatches(`\d+`, "PRICE"))
+	assert.Equal(0, l.Pos())
 
-	for l.Scan() {
-		fmt.Printf("%s => %s\n", l.Token().Name, l.Token().Text)
-	}
+	assert.True(l.Scan())
+	assert.Equal(3, l.Pos())
+	assert.True(l.Scan())
+	assert.Equal(7, l.Pos(), "Should count the skipped bytes")
+	assert.False(l.Scan())
+	assert.Equal(9, l.Pos(), "Should count the trailing skipped bytes")
 
-	// Output:
-	// WORD => price
-	// PRICE => 12
+	l.Reset()
+	assert.Equal(0, l.Pos(), "Position should be reseted")
 }
 
-// Using custom matcher to parse the cost and drop the $ sign.
-func ExampleNewLexerWithMatchers() {
-	text := `price $12.4`
-	l := lexer.NewLexerWithMatchers(text, []lexer.TokenMatcher{
-		lexer.TokenizeIfMatches(`\w+`, "WORD"),
-		lexer.SkipIfMatches(`\s+`),
-	})
-	l.AddMatcher(func(input []byte) (matched bool, shift int, tokenName interface{}, tokenText []byte) {
-		// Don't forget to add special symbol '^'
-		re := regexp.MustCompile(`^\$(\d+(?:\.\d+))`)
-		match := re.FindSubmatch(input)
-		if match == nil {
-			return
-		}
-		return true, len(match[0]), "PRICE", match[1]
-	})
+func TestLexer_Step(t *testing.T) {
+	assert := assert.New(t)
 
-	for l.Scan() {
-		fmt.Printf("%s => %s\n", l.Token().Name, l.Token().Text)
+	l := lexer.NewLexer(`foo  123`)
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.TokenizeIfMatches(`[a-z]+`, "WORD"))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\d+`, "DIGIT"))
+
+	r := l.Step()
+	assert.True(r.Ok)
+	assert.Equal("WORD", r.Token.Name)
+	assert.Equal(1, r.Matcher)
+	assert.Equal(3, r.Consumed)
+	assert.Equal(3, r.Pos)
+	assert.Equal([]byte("  123"), r.Remaining)
+
+	r = l.Step()
+	assert.True(r.Ok)
+	assert.Equal("DIGIT", r.Token.Name)
+	assert.Equal(2, r.Matcher)
+	assert.Equal(5, r.Consumed, "Should count the skipped bytes")
+	assert.Equal(8, r.Pos)
+	assert.Equal([]byte{}, r.Remaining)
+
+	r = l.Step()
+	assert.False(r.Ok)
+	assert.Nil(r.Token)
+	assert.Equal(-1, r.Matcher)
+	assert.Equal(0, r.Consumed)
+}
+
+func TestTokenizeIfMatchesSafe(t *testing.T) {
+	assert := assert.New(t)
+
+	m, err := lexer.TokenizeIfMatchesSafe(`(\d+`, "DIGIT")
+	assert.Error(err, "Should report the invalid pattern")
+	assert.Nil(m)
+	assert.Panics(func() { lexer.TokenizeIfMatches(`(\d+`, "DIGIT") })
+
+	m, err = lexer.TokenizeIfMatchesSafe(`\d+`, "DIGIT")
+	assert.NoError(err)
+	s, err := lexer.SkipIfMatchesSafe(`\s+`)
+	assert.NoError(err)
+	l := lexer.NewLexerWithMatchers(` 12`, []lexer.TokenMatcher{s, m})
+	assert.True(l.Scan())
+	assert.Equal([]byte("12"), l.Token().Text)
+}
+
+func TestSkipIfMatchesSafe(t *testing.T) {
+	assert := assert.New(t)
+
+	m, err := lexer.SkipIfMatchesSafe(`[`)
+	assert.Error(err, "Should report the invalid pattern")
+	assert.Nil(m)
+	assert.Panics(func() { lexer.SkipIfMatches(`[`) })
+}
+
+func TestTokenizeCaptures(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`price $12.4`)
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	l.AddMatcher(lexer.TokenizeCaptures(`\$(\d+)(?:\.(\d+))?`, "PRICE"))
+
+	assert.True(l.Scan())
+	assert.Nil(l.Token().Captures)
+	assert.True(l.Scan())
+	assert.Equal("PRICE", l.Token().Name)
+	assert.Equal([]byte("$12.4"), l.Token().Text)
+	assert.Equal([]byte("$12.4"), l.Token().Raw)
+	assert.Equal([][]byte{[]byte("$12.4"), []byte("12"), []byte("4")}, l.Token().Captures)
+}
+
+func TestTokenizeIfMatchesFold(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`IF If iF fi`)
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.TokenizeIfMatchesFold(`if`, "IF"))
+
+	for _, text := range []string{"IF", "If", "iF"} {
+		assert.True(l.Scan())
+		assert.Equal("IF", l.Token().Name)
+		assert.Equal([]byte(text), l.Token().Text)
 	}
+	assert.False(l.Scan())
+	assert.Error(l.Error, "Should not match in the middle of text")
+}
 
-	// Output:
-	// WORD => price
-	// PRICE => 12.4
+func TestLexer_MaxInputLen(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`foo bar`)
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	l.MaxInputLen = 6
+
+	assert.False(l.Scan())
+	assert.Error(l.Error, "Should reject too long input")
+	assert.Equal(0, l.Pos())
+
+	l.Reset()
+	l.MaxInputLen = 7
+	assert.True(l.Scan())
+	assert.True(l.Scan())
+	assert.NoError(l.Error)
+}
+
+func TestLexer_MaxTokenLen(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`foo barbaz`)
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	l.MaxTokenLen = 3
+
+	assert.True(l.Scan())
+	assert.Equal([]byte("foo"), l.Token().Text)
+	assert.False(l.Scan())
+	assert.Nil(l.Token())
+	assert.Error(l.Error, "Should reject too long token")
+	assert.Equal(4, l.Pos(), "Should not consume too long token")
+}
+
+func TestLexer_LongestMatch(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer("<= <<= if iff")
+	l.AddMatcher(lexer.TokenizeIfMatches(`<`, "LT"))
+	l.AddMatcher(lexer.TokenizeIfMatches(`<=`, "LE"))
+	l.AddMatcher(lexer.TokenizeIfMatches(`<<=`, "SHL_ASSIGN"))
+	l.AddMatcher(lexer.TokenizeIfMatches(`[a-z]+`, "IDENT"))
+	l.AddMatcher(lexer.TokenizeIfMatches(`if`, "IF"))
+	l.AddSkip(lexer.SkipIfMatches(`\s+`))
+	l.LongestMatch = true
+
+	var names []interface{}
+	for l.Scan() {
+		names = append(names, l.Token().Name)
+	}
+	assert.NoError(l.Error)
+	assert.Equal([]interface{}{"LE", "SHL_ASSIGN", "IDENT", "IDENT"}, names, "Should prefer the earlier matcher on a tie")
+
+	l.Reset()
+	l.SetTieBreaker(func(a, b lexer.MatchCandidate) bool {
+		return a.Name == "IF"
+	})
+	names = nil
+	for l.Scan() {
+		names = append(names, l.Token().Name)
+	}
+	assert.Equal([]interface{}{"LE", "SHL_ASSIGN", "IF", "IDENT"}, names)
+}
+
+func TestLexer_PreferTokens(t *testing.T) {
+	assert := assert.New(t)
+
+	newLexer := func() *lexer.Lexer {
+		l := lexer.NewLexer("a--  b")
+		l.AddMatcher(lexer.SkipIfMatches(`[\s-]+`))
+		l.AddMatcher(lexer.TokenizeIfMatches(`--`, "DECREMENT"))
+		l.AddMatcher(lexer.TokenizeIfMatches(`[a-z]`, "WORD"))
+		return l
+	}
+
+	tokens, err := newLexer().ScanAll()
+	assert.NoError(err)
+	assert.Equal(2, len(tokens), "Should let the earlier skip swallow the token")
+
+	l := newLexer()
+	l.PreferTokens = true
+	tokens, err = l.ScanAll()
+	assert.NoError(err)
+	assert.Equal(3, len(tokens))
+	assert.Equal("DECREMENT", tokens[1].Name)
+	assert.Equal(1, tokens[1].Offset)
+	assert.Equal(2, tokens[2].LeadingSkip)
+
+	l = newLexer()
+	l.PreferTokens = true
+	l.LongestMatch = true
+	tokens, err = l.ScanAll()
+	assert.NoError(err)
+	assert.Equal(3, len(tokens), "Should prefer the token to the longer skip")
+	assert.Equal("DECREMENT", tokens[1].Name)
+}
+
+func TestLexer_StrictAmbiguity(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`x if`)
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.TokenizeIfMatches(`if`, "IF"))
+	l.AddMatcher(lexer.TokenizeIfMatches(`[a-z]+`, "IDENT"))
+	l.StrictAmbiguity = true
+
+	assert.True(l.Scan())
+	assert.Equal("IDENT", l.Token().Name)
+	assert.False(l.Scan())
+	assert.Error(l.Error, "Should report the ambiguous matchers")
+	assert.Contains(l.Error.Error(), "[1 2]")
+
+	l.Reset()
+	l.StrictAmbiguity = false
+	assert.True(l.Scan())
+	assert.True(l.Scan())
+	assert.Equal("IF", l.Token().Name, "Should take the first matcher")
+}
+
+func TestLexer_StrictAmbiguityStats(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`1`)
+	l.AddMatcher(lexer.TokenizeIfMatches(`[a-z]+`, "WORD"))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\d+`, "DIGIT"))
+	l.StrictAmbiguity = true
+	l.CollectStats = true
+
+	var trace []string
+	l.SetTrace(func(i int, matched bool, shift int) {
+		trace = append(trace, fmt.Sprintf("%d:%v:%d", i, matched, shift))
+	})
+	assert.True(l.Scan())
+	assert.Equal([]string{"0:false:0", "1:true:1"}, trace, "Should not trace the ambiguity check")
+	assert.Equal(map[int]lexer.MatcherStats{
+		0: {Attempts: 1},
+		1: {Attempts: 1, Matches: 1},
+	}, l.Stats(), "Should not count the ambiguity check")
+}
+
+func TestLexer_AddFilter(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`foo # bar baz`)
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.TokenizeIfMatches(`#`, "HASH"))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	l.AddFilter(func(t *lexer.Token) (*lexer.Token, bool) {
+		return t, t.Name != "HASH"
+	}).AddFilter(func(t *lexer.Token) (*lexer.Token, bool) {
+		return lexer.NewToken(t.Name, bytes.ToUpper(t.Text)), true
+	})
+
+	for _, text := range []string{"FOO", "BAR", "BAZ"} {
+		assert.True(l.Scan())
+		assert.Equal("WORD", l.Token().Name)
+		assert.Equal([]byte(text), l.Token().Text)
+	}
+	assert.False(l.Scan())
+	assert.NoError(l.Error)
+}
+
+func TestLexer_ErrorRecovery(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`foo ✗? bar`)
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	l.ErrorRecovery = true
+
+	for _, token := range []struct {
+		name interface{}
+		text string
+	}{{"WORD", "foo"}, {lexer.ERROR, "✗"}, {lexer.ERROR, "?"}, {"WORD", "bar"}} {
+		assert.True(l.Scan())
+		assert.Equal(token.name, l.Token().Name)
+		assert.Equal([]byte(token.text), l.Token().Text)
+	}
+	assert.False(l.Scan())
+	assert.NoError(l.Error)
+}
+
+func TestLexer_AtEOF(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`foo `)
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	assert.False(l.AtEOF())
+	assert.True(l.Scan())
+	assert.False(l.AtEOF(), "Should not count the trailing space as consumed")
+	assert.False(l.Scan())
+	assert.True(l.AtEOF())
+
+	l = lexer.NewLexer(`foo ?`)
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	assert.True(l.Scan())
+	assert.False(l.Scan())
+	assert.False(l.AtEOF(), "Should stop before the unmatched text")
+}
+
+func TestLexer_LeadingSkip(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer("if x:\n    y\n\ty")
+	l.AddMatcher(lexer.SkipIfMatches(`[ \t]+`))
+	l.AddMatcher(lexer.SkipIfMatches(`\n`))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	l.AddMatcher(lexer.TokenizeIfMatches(`:`, "COLON"))
+
+	for _, token := range []struct {
+		text string
+		skip int
+	}{{"if", 0}, {"x", 1}, {":", 0}, {"y", 5}, {"y", 2}} {
+		assert.True(l.Scan())
+		assert.Equal([]byte(token.text), l.Token().Text)
+		assert.Equal(token.skip, l.Token().LeadingSkip)
+	}
+	assert.False(l.Scan())
+}
+
+func TestLexer_Resume(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer("foo 12 bar")
+	l.AddMatcher(lexer.TokenizeIfMatches(`[a-z]+`, "WORD"))
+	l.AddSkip(lexer.SkipIfMatches(`\s+`))
+
+	assert.True(l.Scan())
+	assert.False(l.Scan())
+	assert.Error(l.Error)
+	assert.False(l.Scan(), "Should stay stopped until resumed")
+
+	l.AddMatcher(lexer.TokenizeIfMatches(`\d+`, "NUMBER"))
+	assert.True(l.Resume())
+	assert.NoError(l.Error)
+	assert.Equal("NUMBER", l.Token().Name)
+	assert.Equal(4, l.Token().Offset)
+	assert.True(l.Scan())
+	assert.Equal("bar", string(l.Token().Text))
+	assert.False(l.Scan())
+	assert.NoError(l.Error)
+}
+
+func TestLexer_Next(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer("foo bar")
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+
+	token, err := l.Next()
+	assert.NoError(err)
+	assert.Equal("foo", string(token.Text))
+	token, err = l.Next()
+	assert.NoError(err)
+	assert.Equal("bar", string(token.Text))
+	token, err = l.Next()
+	assert.Nil(token, "Should return nil token at the end of input")
+	assert.NoError(err)
+
+	l = lexer.NewLexer("foo ?")
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	_, err = l.Next()
+	assert.NoError(err)
+	token, err = l.Next()
+	assert.Nil(token)
+	assert.Error(err, "Should return the scanning error")
+}
+
+func TestLexer_ScanN(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`a b c ?`)
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+
+	tokens, err := l.ScanN(2)
+	assert.NoError(err)
+	assert.Equal(2, len(tokens))
+	assert.Equal([]byte("a"), tokens[0].Text)
+	assert.Equal([]byte("b"), tokens[1].Text)
+
+	tokens, err = l.ScanN(2)
+	assert.Error(err, "Should stop on the unmatched text")
+	assert.Equal(1, len(tokens))
+	assert.Equal([]byte("c"), tokens[0].Text)
+}
+
+func TestLexer_AddEOFMatcher(t *testing.T) {
+	assert := assert.New(t)
+
+	var calls int
+	l := lexer.NewLexer("foo \n")
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	l.AddEOFMatcher(func(input []byte) (bool, int, interface{}, []byte) {
+		calls++
+		return true, 0, "END", nil
+	})
+	l.AddEOFMatcher(func(input []byte) (bool, int, interface{}, []byte) {
+		calls++
+		return false, 0, nil, nil
+	})
+	l.AddEOFMatcher(func(input []byte) (bool, int, interface{}, []byte) {
+		calls++
+		return true, 0, "EOF", nil
+	})
+
+	assert.True(l.Scan())
+	assert.Equal("WORD", l.Token().Name)
+	assert.Equal(0, calls, "Should not run before the end of input")
+	assert.True(l.Scan())
+	assert.Equal("END", l.Token().Name)
+	assert.Equal(2, l.Token().Line)
+	assert.Equal(2, l.Token().LeadingSkip)
+	assert.True(l.Scan())
+	assert.Equal("EOF", l.Token().Name)
+	assert.False(l.Scan())
+	assert.False(l.Scan())
+	assert.Equal(3, calls, "Should run each matcher exactly once")
+	assert.NoError(l.Error)
+
+	l = lexer.NewLexer("foo ?")
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	l.AddEOFMatcher(func(input []byte) (bool, int, interface{}, []byte) {
+		return true, 0, "END", nil
+	})
+	assert.True(l.Scan())
+	assert.False(l.Scan(), "Should not run on unmatched text")
+}
+
+func TestLexer_CopyTokenText(t *testing.T) {
+	assert := assert.New(t)
+
+	input := []byte(`foo bar`)
+	l := lexer.NewLexerFromBytes(input)
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	assert.True(l.Scan())
+	aliased := l.Token()
+
+	l.CopyTokenText = true
+	assert.True(l.Scan())
+	copied := l.Token()
+
+	copy(input, "xxx yyy")
+	assert.Equal([]byte("xxx"), aliased.Text, "Should refer to the input by default")
+	assert.Equal([]byte("bar"), copied.Text)
+	assert.Equal([]byte("bar"), copied.Raw)
+
+	input = []byte(`a=b`)
+	l = lexer.NewLexerFromBytes(input)
+	l.AddMatcher(lexer.TokenizeCaptures(`(\w+)=(\w+)`, "PAIR"))
+	l.CopyTokenText = true
+	assert.True(l.Scan())
+	copied = l.Token()
+
+	copy(input, "x=y")
+	assert.Equal([][]byte{[]byte("a=b"), []byte("a"), []byte("b")}, copied.Captures, "Should copy the captures too")
+}
+
+func TestLexer_Seek(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer("foo\nbar baz ?")
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	assert.True(l.Scan())
+	mark := l.Mark()
+	assert.Equal(3, mark)
+
+	assert.True(l.Scan())
+	assert.True(l.Scan())
+	assert.False(l.Scan())
+	assert.Error(l.Error)
+
+	assert.NoError(l.Seek(mark))
+	assert.Nil(l.Token())
+	assert.NoError(l.Error)
+	assert.Equal(mark, l.Pos())
+	assert.True(l.Scan())
+	assert.Equal([]byte("bar"), l.Token().Text)
+	assert.Equal(2, l.Token().Line)
+	assert.Equal(1, l.Token().Column)
+
+	assert.Error(l.Seek(-1))
+	assert.Error(l.Seek(100))
+	assert.Equal(7, l.Pos(), "Should not move on invalid mark")
+}
+
+func TestLexer_SeekRewind(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer("x a //c")
+	l.AddMatcher(lexer.TokenizeIfMatches(`[a-z]`, "WORD"))
+	l.AddMatcher(lexer.CollectIfMatches(`//[^\n]*`, "COMMENT"))
+	l.AddSkip(lexer.SkipIfMatches(`\s+`))
+	l.MaxTokens = 2
+
+	assert.True(l.Scan())
+	mark := l.Mark()
+	for i := 0; i < 3; i++ {
+		assert.NoError(l.Seek(mark))
+		tokens, err := l.ScanAll()
+		assert.NoError(err, "Should not count the rescanned tokens")
+		assert.Equal(1, len(tokens))
+		assert.Equal(1, len(l.Trivia()), "Should drop the trivia after the mark")
+	}
+
+	l = lexer.NewLexer("a\n  b")
+	l.AddMatcher(lexer.TokenizeIfMatches(`[a-z]`, "WORD"))
+	l.AddSkip(lexer.SkipIfMatches(`\s+`))
+	l.EnableIndentation("INDENT", "DEDENT")
+	assert.True(l.Scan())
+	assert.EqualError(l.Seek(l.Mark()), "Seek to 1 isn't supported with the indentation, use Save and Restore")
+	assert.NoError(l.Seek(0))
+	tokens, err := l.ScanAll()
+	assert.NoError(err)
+	assert.Equal(4, len(tokens))
+}
+
+func TestLexer_TabWidth(t *testing.T) {
+	assert := assert.New(t)
+
+	scan := func(l *lexer.Lexer) []int {
+		l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+		l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+		var columns []int
+		for l.Scan() {
+			columns = append(columns, l.Token().Column)
+		}
+		return columns
+	}
+
+	l := lexer.NewLexer("\ta\tb ab\tc")
+	assert.Equal([]int{2, 4, 6, 9}, scan(l), "Should count a tab as one column by default")
+
+	l = lexer.NewLexer("\ta\tb ab\tc")
+	l.TabWidth = 4
+	assert.Equal([]int{5, 10, 12, 18}, scan(l))
+
+	l = lexer.NewLexer("\ta\tb ab\tc")
+	l.TabWidth = 4
+	l.TabStops = true
+	assert.Equal([]int{5, 9, 11, 17}, scan(l))
+}
+
+func TestLexer_Stats(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`foo 12 bar`)
+	l.AddMatcher(lexer.TokenizeIfMatches(`\d+`, "DIGIT"))
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	for l.Scan() {
+	}
+	assert.Empty(l.Stats(), "Should not collect stats by default")
+
+	l.Reset()
+	l.CollectStats = true
+	for l.Scan() {
+	}
+	assert.Equal(map[int]lexer.MatcherStats{
+		0: {Attempts: 5, Matches: 1},
+		1: {Attempts: 4, Matches: 2},
+		2: {Attempts: 2, Matches: 2},
+	}, l.Stats())
+
+	l.Reset()
+	assert.Equal(5, l.Stats()[0].Attempts, "Should not be reseted")
+}
+
+func TestLexer_SetTrace(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`a 1`)
+	l.AddMatcher(lexer.TokenizeIfMatches(`[a-z]+`, "WORD"))
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\d+`, "DIGIT"))
+
+	var trace []string
+	l.SetTrace(func(i int, matched bool, shift int) {
+		trace = append(trace, fmt.Sprintf("%d:%v:%d", i, matched, shift))
+	})
+	for l.Scan() {
+	}
+	assert.Equal([]string{
+		"0:true:1",
+		"0:false:0", "1:false:1",
+		"0:false:0", "1:false:0", "2:true:1",
+	}, trace)
+
+	trace = nil
+	l.Reset()
+	l.SetTrace(nil)
+	assert.True(l.Scan())
+	assert.Empty(trace)
+}
+
+func TestLexer_ScanInto(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer("foo 12")
+	l.AddMatcher(lexer.TokenizeIfMatches(`[a-z]+`, "WORD"))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\d+`, "NUMBER"))
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+
+	var token lexer.Token
+	assert.True(l.ScanInto(&token))
+	assert.Equal("WORD", token.Name)
+	assert.Equal([]byte("foo"), token.Text)
+	assert.True(&token == l.Token(), "Should make the token current")
+
+	assert.True(l.ScanInto(&token))
+	assert.Equal("NUMBER", token.Name)
+	assert.Equal([]byte("12"), token.Text)
+	assert.Equal(4, token.Offset)
+	assert.Equal(1, token.LeadingSkip)
+
+	l.UnScan(&token)
+	var other lexer.Token
+	assert.True(l.ScanInto(&other))
+	assert.Equal("NUMBER", other.Name, "Should copy the pushed back token")
+	assert.False(l.ScanInto(&token))
+	assert.NoError(l.Error)
+}
+
+func TestLexer_ScanIntoIndentation(t *testing.T) {
+	assert := assert.New(t)
+
+	newLexer := func() *lexer.Lexer {
+		l := lexer.NewLexer("a\n  b\nc")
+		l.AddMatcher(lexer.TokenizeIfMatches(`[a-z]`, "W"))
+		l.AddSkip(lexer.SkipIfMatches(`\s+`))
+		return l.EnableIndentation("INDENT", "DEDENT")
+	}
+
+	var expected []string
+	l := newLexer()
+	for l.Scan() {
+		expected = append(expected, l.Token().String())
+	}
+
+	var actual []string
+	var token lexer.Token
+	l = newLexer()
+	for l.ScanInto(&token) {
+		actual = append(actual, token.String())
+	}
+	assert.NoError(l.Error)
+	assert.Equal(expected, actual)
+	assert.Equal(5, len(actual))
+}
+
+func TestLexer_SetErrorFormatter(t *testing.T) {
+	assert := assert.New(t)
+
+	errSyntax := fmt.Errorf("syntax error")
+	l := lexer.NewLexer("ab!")
+	l.AddMatcher(lexer.TokenizeIfMatches(`[a-z]`, "LETTER"))
+	l.SetErrorFormatter(func(offset int, remaining []byte) error {
+		assert.Equal(2, offset)
+		assert.Equal([]byte("!"), remaining)
+		return errSyntax
+	})
+
+	assert.True(l.Scan())
+	assert.True(l.Scan())
+	assert.False(l.Scan())
+	assert.Equal(errSyntax, l.Error)
+
+	l.Reset()
+	l.SetErrorFormatter(nil)
+	for l.Scan() {
+	}
+	assert.EqualError(l.Error, `Can't match any existed matchers at 1:3 (offset 2) for the following text: "!"`)
+}
+
+func TestLexer_NameCounts(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`{a {b}} c`)
+	l.AddMatcher(lexer.TokenizeIfMatches(`\{`, "LBRACE"))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\}`, "RBRACE"))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w`, "WORD"))
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+
+	for l.Scan() {
+	}
+	assert.Empty(l.NameCounts(), "Should not count without CollectStats")
+
+	l.Reset()
+	l.CollectStats = true
+	for l.Scan() {
+	}
+	assert.Equal(map[interface{}]int{"LBRACE": 2, "RBRACE": 2, "WORD": 3}, l.NameCounts())
+
+	c := l.Clone()
+	c.Reset()
+	for c.Scan() {
+	}
+	assert.Equal(2, l.NameCounts()["LBRACE"], "Should not share counts with the clone")
+	assert.Equal(4, c.NameCounts()["LBRACE"])
+}
+
+func TestLexer_LastPattern(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`foo 12 [`)
+	l.AddMatcher(lexer.TokenizeIfMatches(`[a-z]+`, "WORD"))
+	l.AddMatcher(lexer.TokenizeIfMatchesFold(`\d+`, "DIGIT"))
+	l.AddMatcher(lexer.TokenizeBalanced('[', ']', "GROUP"))
+	l.AddMatcher(lexer.TokenizeAnyRune("ANY"))
+	l.AddSkip(lexer.SkipIfMatches(`\s+`))
+	l.SkipFirst = true
+
+	assert.Equal("", l.LastPattern())
+	assert.True(l.Scan())
+	assert.Equal("[a-z]+", l.LastPattern())
+	assert.True(l.Scan())
+	assert.Equal(`\d+`, l.LastPattern(), "Should return the original pattern")
+	assert.True(l.Scan())
+	assert.Equal("ANY", l.Token().Name)
+	assert.Equal("", l.LastPattern(), "Should not describe the custom matchers")
+
+	l = lexer.NewLexer(`_`)
+	l.AddMatcher(lexer.StopMatcher(lexer.TokenizeIfMatches(`_`, "UNDERSCORE")))
+	assert.True(l.Scan())
+	assert.Equal("", l.LastPattern(), "Should not describe the wrapped matchers")
+}
+
+func TestLexer_MatchingMatchers(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`if x`)
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "IDENT"))
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.TokenizeIfMatches(`if`, "IF"))
+	l.AddMatcher(lexer.TokenizeIfMatches(`i`, "I"))
+	l.CollectStats = true
+
+	assert.Nil(l.MatchingMatchers())
+	assert.True(l.Scan())
+	assert.Equal("IDENT", l.Token().Name)
+	assert.Equal([]int{0, 2, 3}, l.MatchingMatchers())
+	assert.Equal(1, l.Stats()[0].Attempts, "Should not count the statistics")
+	assert.True(l.Scan())
+	assert.Equal([]int{0}, l.MatchingMatchers())
+}
+
+func TestLexer_Validate(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`foo`)
+	assert.EqualError(l.Validate(), "No matchers are registered")
+
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.TokenizeAnyRune("ANY"))
+	assert.NoError(l.Validate())
+
+	l.AddMatcher(lexer.SkipIfMatches(`\s*`))
+	l.AddMatcher(lexer.TokenizeIfMatches(`^\w+`, "IDENT"))
+	l.AddMatcher(func(input []byte) (bool, int, interface{}, []byte) {
+		panic(input[1])
+	})
+	var err error
+	assert.NotPanics(func() {
+		err = l.Validate()
+	}, "Should not call the custom matchers")
+	assert.Error(err)
+	assert.Contains(err.Error(), "Matcher 3 matches the empty string")
+	assert.Contains(err.Error(), `Matchers 0 and 4 have the same pattern "^\\w+"`)
+	assert.NotContains(err.Error(), "Matcher 5")
+
+	l = lexer.NewLexer(`foo`)
+	l.AddRules([]lexer.Rule{
+		{Pattern: `\w+`, Name: "WORD"},
+		{Pattern: `\s*`, Skip: true},
+	})
+	l.Add(lexer.NewRegexpMatcher(`\w+`, "IDENT"))
+	err = l.Validate()
+	assert.Error(err)
+	assert.Contains(err.Error(), "Matcher 1 matches the empty string", "Should check the rules")
+	assert.Contains(err.Error(), "Matchers 0 and 2 have the same pattern")
+
+	l = lexer.NewLexer(`foo`)
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	assert.True(l.Scan(), "Should not affect scanning")
+	assert.Equal("foo", string(l.Token().Text))
+}
+
+func TestLexer_ErrorSnippet(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer("foo\n  " + strings.Repeat("ы", 20))
+	l.AddMatcher(lexer.TokenizeIfMatches(`[a-z]+`, "WORD"))
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+
+	assert.True(l.Scan())
+	assert.False(l.Scan())
+	assert.EqualError(l.Error, `Can't match any existed matchers at 2:3 (offset 6) for the following text: "`+strings.Repeat("ы", 16)+`..."`)
+
+	l.Reset()
+	l.ErrorSnippetLen = 5
+	assert.True(l.Scan())
+	assert.False(l.Scan())
+	assert.Contains(l.Error.Error(), `"ыы..."`, "Should truncate at the rune boundary")
+
+	l.Reset()
+	l.ErrorSnippetLen = 100
+	assert.True(l.Scan())
+	assert.False(l.Scan())
+	assert.Contains(l.Error.Error(), `"`+strings.Repeat("ы", 20)+`"`)
+}
+
+func TestLexer_SkipBOM(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, text := range []string{"\xef\xbb\xbffoo bar", "foo bar"} {
+		l := lexer.NewLexer(text)
+		l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+		l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+		l.SkipBOM = true
+		bom := len(text) - len("foo bar")
+
+		assert.True(l.Scan())
+		assert.Equal("foo", string(l.Token().Text))
+		assert.Equal(1, l.Token().Column)
+		assert.Equal(bom, l.Token().Offset)
+		assert.Equal(0, l.Token().LeadingSkip)
+		assert.True(l.Scan())
+		assert.Equal(5, l.Token().Column)
+
+		assert.NoError(l.Seek(bom + 4))
+		assert.True(l.Scan())
+		assert.Equal(5, l.Token().Column, "Should not count the BOM column on seek")
+	}
+
+	l := lexer.NewLexer("\xef\xbb\xbffoo")
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	assert.False(l.Scan(), "Should not skip the BOM by default")
+}
+
+func TestLexer_LineEndings(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer("a\r\nb\rc\nd\r\n\r\ne")
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w`, "WORD"))
+	l.AddMatcher(lexer.SkipIfMatches(`\r`))
+	l.AddMatcher(lexer.SkipIfMatches(`\n`))
+
+	var positions [][2]int
+	for l.Scan() {
+		positions = append(positions, [2]int{l.Token().Line, l.Token().Column})
+	}
+	assert.NoError(l.Error)
+	assert.Equal([][2]int{{1, 1}, {2, 1}, {3, 1}, {4, 1}, {6, 1}}, positions)
+
+	assert.NoError(l.Seek(3))
+	assert.True(l.Scan())
+	assert.Equal(2, l.Token().Line, "Should collapse \\r\\n on seek")
+}
+
+func TestTokenizeIfMatchesTransform(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`1_000 Foo`)
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.TokenizeIfMatchesTransform(`\d[\d_]*`, "INT", func(b []byte) []byte {
+		return bytes.Replace(b, []byte("_"), nil, -1)
+	}))
+	l.AddMatcher(lexer.TokenizeIfMatchesTransform(`\w+`, "IDENT", bytes.ToLower))
+
+	assert.True(l.Scan())
+	assert.Equal("1000", string(l.Token().Text))
+	assert.Equal("1_000", string(l.Token().Raw))
+	assert.True(l.Scan())
+	assert.Equal("foo", string(l.Token().Text))
+	assert.Equal("Foo", string(l.Token().Raw))
+	assert.Equal("1_000 Foo", l.Input, "Should not modify the input")
+}
+
+func TestTokenizeIfMatchesNotFollowedBy(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`let letter let`)
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.TokenizeIfMatchesNotFollowedBy(`let`, `\w`, "LET"))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "IDENT"))
+
+	for _, token := range [][]string{
+		{"let", "LET"},
+		{"letter", "IDENT"},
+		{"let", "LET"},
+	} {
+		assert.True(l.Scan())
+		assert.Equal(token[1], l.Token().Name)
+		assert.Equal([]byte(token[0]), l.Token().Text)
+	}
+	assert.False(l.Scan())
+	assert.NoError(l.Error)
+}
+
+func TestTokenizeIfMatchesMax(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`abcdefg hi`)
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.TokenizeIfMatchesMax(`\w+`, "WORD", 3))
+
+	for _, text := range []string{"abc", "def", "g", "hi"} {
+		assert.True(l.Scan())
+		assert.Equal(text, string(l.Token().Text))
+		assert.Equal(text, string(l.Token().Raw))
+	}
+	assert.False(l.Scan())
+	assert.NoError(l.Error)
+}
+
+func TestTokenizeIfMatchesPOSIX(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`abc`)
+	l.AddMatcher(lexer.TokenizeIfMatches(`a|ab`, "A"))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w`, "C"))
+	assert.True(l.Scan())
+	assert.Equal("a", string(l.Token().Text))
+
+	l = lexer.NewLexer(`ab  c`)
+	l.AddMatcher(lexer.TokenizeIfMatchesPOSIX(`a|ab`, "A"))
+	l.AddMatcher(lexer.SkipIfMatchesPOSIX(` | +`))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w`, "C"))
+	assert.True(l.Scan())
+	assert.Equal("ab", string(l.Token().Text), "Should match the longest alternative")
+	assert.True(l.Scan())
+	assert.Equal(2, l.Token().LeadingSkip)
+	assert.Equal("c", string(l.Token().Text))
+
+	assert.Panics(func() { lexer.TokenizeIfMatchesPOSIX(`\d`, "D") }, "Should use POSIX syntax")
+}
+
+func TestTokenizeIfMatchesRaw(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`-b`)
+	l.AddMatcher(lexer.TokenizeIfMatches(`a|b`, "AB"))
+	assert.True(l.Scan(), "Should anchor the first alternative only")
+	assert.Equal("b", string(l.Token().Text))
+
+	l = lexer.NewLexer(`ba  a`)
+	l.AddMatcher(lexer.TokenizeIfMatchesRaw(`a|b`, "AB"))
+	l.AddMatcher(lexer.SkipIfMatchesRaw(`\s+`))
+	for _, text := range []string{"b", "a", "a"} {
+		assert.True(l.Scan())
+		assert.Equal(text, string(l.Token().Text))
+	}
+	assert.False(l.Scan())
+	assert.NoError(l.Error)
+
+	l = lexer.NewLexer(`-b`)
+	l.AddMatcher(lexer.TokenizeIfMatchesRaw(`^a|^b`, "AB"))
+	l.AddMatcher(lexer.TokenizeIfMatchesRaw(`a|b`, "AB"))
+	assert.False(l.Scan())
+	assert.Error(l.Error, "Should not accept the match after the current position")
+}
+
+func TestTokenizeWithValue(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`12 3.5 99999999999999999999`)
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.TokenizeWithValue(`\d+\.\d+`, "FLOAT", func(b []byte) (interface{}, error) {
+		return strconv.ParseFloat(string(b), 64)
+	}))
+	l.AddMatcher(lexer.TokenizeWithValue(`\d+`, "INT", func(b []byte) (interface{}, error) {
+		return strconv.Atoi(string(b))
+	}))
+
+	assert.True(l.Scan())
+	assert.Equal("INT", l.Token().Name)
+	assert.Equal(12, l.Token().Value)
+	assert.Equal("12", string(l.Token().Raw))
+	assert.True(l.Scan())
+	assert.Equal("FLOAT", l.Token().Name)
+	assert.Equal(3.5, l.Token().Value)
+	assert.False(l.Scan())
+	assert.Error(l.Error, "Should stop on the conversion error")
+	assert.Contains(l.Error.Error(), "out of range")
+	assert.Equal(7, l.Pos(), "Should not consume the input")
+}
+
+func TestLexer_StrictAnchors(t *testing.T) {
+	assert := assert.New(t)
+
+	unanchored := func(input []byte) (bool, int, interface{}, []byte) {
+		re := regexp.MustCompile(`\d+`)
+		match := re.Find(input)
+		return match != nil, len(match), "DIGIT", match
+	}
+	l := lexer.NewLexer(`foo-12`)
+	l.AddMatcher(lexer.TokenizeIfMatches(`[a-z]+`, "WORD"))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\+`, "PLUS"))
+	l.AddMatcher(unanchored)
+	l.StrictAnchors = true
+
+	assert.True(l.Scan())
+	assert.False(l.Scan())
+	assert.IsType(&lexer.UnanchoredMatchError{}, l.Error)
+	assert.Equal(&lexer.UnanchoredMatchError{Matcher: 2, Pos: 3, Offset: 1}, l.Error)
+	assert.Equal(3, l.Pos(), "Should not consume the input")
+
+	l = lexer.NewLexer(`12 foo`)
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(unanchored)
+	l.AddMatcher(lexer.TokenizeIfMatches(`[a-z]+`, "WORD"))
+	l.StrictAnchors = true
+	assert.True(l.Scan())
+	assert.True(l.Scan())
+	assert.NoError(l.Error, "Should accept the matches at the current position")
+
+	l = lexer.NewLexer(`"foo" price $12.4`)
+	l.AddMatcher(lexer.TokenizeQuoted('"', "STRING"))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(func(input []byte) (bool, int, interface{}, []byte) {
+		match := regexp.MustCompile(`^\$(\d+(?:\.\d+))`).FindSubmatch(input)
+		if match == nil {
+			return false, 0, nil, nil
+		}
+		return true, len(match[0]), "PRICE", match[1]
+	})
+	l.StrictAnchors = true
+	tokens, err := l.ScanAll()
+	assert.NoError(err, "Should accept the bodies within the consumed input")
+	assert.Equal(3, len(tokens))
+	assert.Equal("12.4", string(tokens[2].Text))
+}
+
+func TestNewLexerMulti(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexerMulti([]lexer.NamedSource{
+		{Name: "a.txt", Text: "foo bar\n"},
+		{Name: "empty.txt", Text: ""},
+		{Name: "b.txt", Text: "baz"},
+	})
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+
+	for _, token := range []struct {
+		text   string
+		source string
+		offset int
+	}{
+		{"foo", "a.txt", 0},
+		{"bar", "a.txt", 4},
+		{"baz", "b.txt", 0},
+	} {
+		assert.True(l.Scan())
+		assert.Equal([]byte(token.text), l.Token().Text)
+		assert.Equal(token.source, l.Token().Source)
+		assert.Equal(token.offset, l.Token().SourceOffset)
+	}
+	assert.Equal(8, l.Token().Offset)
+	assert.False(l.Scan())
+	assert.NoError(l.Error)
+
+	l = lexer.NewLexer(`foo`)
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	assert.True(l.Scan())
+	assert.Equal("", l.Token().Source)
+}
+
+func TestLexer_Progress(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer("ab cd")
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	l.AddSkip(lexer.SkipIfMatches(`\s+`))
+
+	assert.Equal(0.0, l.Progress())
+	l.Scan()
+	assert.InDelta(0.4, l.Progress(), 1e-9)
+	l.ScanAll()
+	assert.Equal(1.0, l.Progress())
+	assert.Equal(1.0, lexer.NewLexer("").Progress(), "Should be complete for the empty input")
+}
+
+func TestLexer_Consumed(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`foo  bar baz`)
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+
+	assert.Empty(l.Consumed())
+	var raw []byte
+	for l.Scan() {
+		raw = append(raw, l.Token().Raw...)
+		assert.Equal([]byte(l.Input[:l.Pos()]), l.Consumed())
+	}
+	assert.Equal([]byte(l.Input), l.Consumed())
+	assert.Equal("foobarbaz", string(raw))
+}
+
+func TestLexer_LineOffsets(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer("ab\ncd\r\ne\rf\ng")
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+
+	assert.Equal([]int{0}, l.LineOffsets())
+	l.ScanN(3)
+	assert.Equal([]int{0, 3, 7}, l.LineOffsets(), "Should cover the consumed input only")
+	tokens, _ := l.ScanN(10)
+	offsets := l.LineOffsets()
+	assert.Equal([]int{0, 3, 7, 9, 11}, offsets)
+	for _, token := range tokens {
+		assert.Equal(token.Line, sort.Search(len(offsets), func(i int) bool {
+			return offsets[i] > token.Offset
+		}))
+	}
+}
+
+func TestToken_Is(t *testing.T) {
+	assert := assert.New(t)
+
+	tok := lexer.NewToken("WORD", []byte("foo"))
+	assert.True(tok.Is("WORD", "foo"))
+	assert.False(tok.Is("WORD", "bar"))
+	assert.False(tok.Is("IDENT", "foo"))
+	assert.False(tok.Is(lexer.SyntheticName("WORD"), "foo"), "Should compare the names' types")
+	assert.Equal("foo", tok.TextString())
+}
+
+func TestToken_Kind(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer("a # b\n?")
+	l.AddMatcher(lexer.TokenizeIfMatches(`[a-z]`, "WORD"))
+	l.AddMatcher(lexer.CollectIfMatches(`#[^\n]*`, "COMMENT"))
+	l.AddSkip(lexer.SkipIfMatches(`\s+`))
+	l.AddEOFMatcher(func(input []byte) (bool, int, interface{}, []byte) {
+		return true, 0, "END", nil
+	})
+	l.ErrorRecovery = true
+
+	tokens, err := l.ScanAll()
+	assert.NoError(err)
+	assert.Equal(3, len(tokens))
+	assert.Equal(lexer.TokenKindNormal, tokens[0].Kind)
+	assert.Equal(lexer.TokenKindError, tokens[1].Kind)
+	assert.Equal(lexer.TokenKindEOF, tokens[2].Kind)
+	assert.Equal(1, len(l.Trivia()))
+	assert.Equal(lexer.TokenKindTrivia, l.Trivia()[0].Kind)
+}
+
+func TestToken_Len(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`foo "bar"`)
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	l.AddMatcher(lexer.TokenizeQuoted('"', "STRING"))
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+
+	assert.True(l.Scan())
+	assert.Equal(3, l.Token().Len())
+	assert.Equal(3, l.Token().EndOffset())
+	assert.True(l.Scan())
+	assert.Equal(5, l.Token().Len(), "Should count the raw text")
+	assert.Equal(9, l.Token().EndOffset())
+
+	assert.Equal(2, lexer.NewToken("WORD", []byte("ab")).Len())
+}
+
+func TestStopMatcher(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer("Host: a\nType: b\n\nbody ?")
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+:`, "KEY"))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "VALUE"))
+	l.AddMatcher(lexer.StopMatcher(lexer.TokenizeIfMatches(`\n\n`, "END")))
+	l.AddMatcher(lexer.SkipIfMatches(`\s`))
+
+	var names []interface{}
+	for l.Scan() {
+		names = append(names, l.Token().Name)
+	}
+	assert.NoError(l.Error)
+	assert.Equal([]interface{}{"KEY", "VALUE", "KEY", "VALUE", "END"}, names)
+	assert.Equal("body ?", string(l.Remaining()))
+	assert.False(l.Scan())
+
+	l.Reset()
+	assert.Equal(l.Input, string(l.Remaining()))
+}
+
+func TestStopMatcher_Error(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer("99999999999999999999 1")
+	l.AddMatcher(lexer.StopMatcher(lexer.NumberMatcher("NUMBER", lexer.NumberOptions{})))
+	assert.False(l.Scan())
+	assert.Error(l.Error, "Should pass the wrapped matcher's error through")
+	assert.Contains(l.Error.Error(), "out of range")
+}
+
+func TestLexer_ScanRange(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer("foo bar\nbaz qux")
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddEOFMatcher(func(input []byte) (bool, int, interface{}, []byte) {
+		return true, 0, "EOF", nil
+	})
+
+	assert.True(l.Scan())
+	tokens, err := l.ScanRange(8, 14)
+	assert.NoError(err)
+	assert.Len(tokens, 2)
+	assert.Equal("baz", string(tokens[0].Text))
+	assert.Equal(8, tokens[0].Offset)
+	assert.Equal(2, tokens[0].Line)
+	assert.Equal(1, tokens[0].Column)
+	assert.Equal("qu", string(tokens[1].Text), "Should stop at the range end")
+	assert.Equal(12, tokens[1].Offset)
+
+	assert.Equal("foo", string(l.Token().Text), "Should not change the lexer state")
+	assert.Equal(3, l.Pos())
+
+	_, err = l.ScanRange(5, 100)
+	assert.Error(err)
+	_, err = l.ScanRange(5, 4)
+	assert.Error(err)
+}
+
+func TestLexer_MatcherPanic(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`a!`)
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w`, "WORD"))
+	l.AddMatcher(func(input []byte) (bool, int, interface{}, []byte) {
+		return true, 1, "BUG", input[5:]
+	})
+
+	assert.True(l.Scan())
+	assert.NotPanics(func() { assert.False(l.Scan()) })
+	assert.IsType(&lexer.MatcherPanicError{}, l.Error)
+	assert.Equal(1, l.Error.(*lexer.MatcherPanicError).Matcher)
+	assert.Contains(l.Error.Error(), "Matcher 1 panicked: ")
+	assert.Equal(1, l.Pos(), "Should not consume the input")
+
+	l.Reset()
+	l.PropagatePanics = true
+	assert.True(l.Scan())
+	assert.Panics(func() { l.Scan() })
+}
+
+func TestLexer_EOFMatcherPanic(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer("a")
+	l.AddMatcher(lexer.TokenizeIfMatches(`a`, "A"))
+	l.AddEOFMatcher(func(input []byte) (bool, int, interface{}, []byte) {
+		var m map[string]int
+		m["boom"]++
+		return true, 0, "END", nil
+	})
+	assert.True(l.Scan())
+	assert.NotPanics(func() {
+		assert.False(l.Scan())
+	})
+	assert.IsType(&lexer.MatcherPanicError{}, l.Error)
+	assert.Equal(-1, l.Error.(*lexer.MatcherPanicError).Matcher)
+
+	errEOF := fmt.Errorf("unclosed block")
+	l = lexer.NewLexer("a")
+	l.AddMatcher(lexer.TokenizeIfMatches(`a`, "A"))
+	l.AddEOFMatcher(func(input []byte) (bool, int, interface{}, []byte) {
+		return true, 0, errEOF, nil
+	})
+	tokens, err := l.ScanAll()
+	assert.Equal(errEOF, err, "Should fail with the error name")
+	assert.Equal(1, len(tokens))
+}
+
+func TestLexer_AddSkip(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`a // b`)
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	l.AddMatcher(lexer.TokenizeIfMatches(`/`, "SLASH"))
+	l.AddSkip(lexer.SkipIfMatches(`\s+`))
+	l.AddSkip(lexer.SkipIfMatches(`//.*`))
+
+	var names []interface{}
+	for l.Scan() {
+		names = append(names, l.Token().Name)
+	}
+	assert.NoError(l.Error)
+	assert.Equal([]interface{}{"WORD", "SLASH", "SLASH", "WORD"}, names, "Should try the token matchers first")
+
+	l.Reset()
+	l.SkipFirst = true
+	l.CollectStats = true
+	names = nil
+	for l.Scan() {
+		names = append(names, l.Token().Name)
+	}
+	assert.NoError(l.Error)
+	assert.Equal([]interface{}{"WORD"}, names, "Should try the skip matchers first")
+	assert.Equal(1, l.Stats()[3].Matches, "Should index the skips after the matchers")
+}
+
+func TestLexer_EnableIndentation(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer("if a:\n  b\n\n  if c:\n    d\ne\nif f:\n\tg")
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	l.AddMatcher(lexer.TokenizeIfMatches(`:`, "COLON"))
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.EnableIndentation("INDENT", "DEDENT")
+	l.TabWidth = 4
+
+	var names []string
+	for l.Scan() {
+		if text := string(l.Token().Text); text != "" {
+			names = append(names, text)
+		} else {
+			names = append(names, l.Token().Name.(string))
+		}
+	}
+	assert.NoError(l.Error)
+	assert.Equal([]string{
+		"if", "a", ":", "INDENT", "b",
+		"if", "c", ":", "INDENT", "d",
+		"DEDENT", "DEDENT", "e",
+		"if", "f", ":", "INDENT", "g", "DEDENT",
+	}, names)
+
+	l.Reset()
+	for i := 0; i < 3; i++ {
+		assert.True(l.Scan())
+	}
+	assert.True(l.Scan())
+	assert.Equal("INDENT", l.Token().Name)
+	assert.Equal(2, l.Token().Line)
+	assert.Equal(3, l.Token().Column)
+	assert.Equal(8, l.Token().Offset)
+	assert.True(l.Scan())
+	assert.Equal("b", string(l.Token().Text))
+	assert.Equal(0, l.LastMatcher, "Should keep the matcher of the pushed back token")
+
+	l = lexer.NewLexer("if a:\n  b\n    ")
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	l.AddMatcher(lexer.TokenizeIfMatches(`:`, "COLON"))
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddEOFMatcher(func(input []byte) (bool, int, interface{}, []byte) {
+		return true, 0, "END", nil
+	})
+	l.EnableIndentation("INDENT", "DEDENT")
+	names = nil
+	for l.Scan() {
+		names = append(names, fmt.Sprint(l.Token().Name))
+	}
+	assert.NoError(l.Error)
+	assert.Equal([]string{
+		"WORD", "WORD", "COLON", "INDENT", "WORD", "DEDENT", "END",
+	}, names, "Should close the levels before the EOF token")
+}
+
+func TestLexer_LongSkipRun(t *testing.T) {
+	assert := assert.New(t)
+	n := 1000000
+
+	l := lexer.NewLexer(strings.Repeat("// c\n", n) + "a")
+	l.AddMatcher(lexer.TokenizeIfMatches(`[a-z]`, "WORD"))
+	l.AddSkip(lexer.SkipIfMatches(`//[^\n]*\n`))
+	tokens, err := l.ScanAll()
+	assert.NoError(err)
+	assert.Equal(1, len(tokens), "Should not overflow the stack on the skips")
+
+	l = lexer.NewLexer(strings.Repeat("c", n) + "a")
+	l.AddMatcher(lexer.TokenizeIfMatches(`[a-z]`, "WORD"))
+	l.AddFilter(func(t *lexer.Token) (*lexer.Token, bool) {
+		return t, string(t.Text) != "c"
+	})
+	tokens, err = l.ScanAll()
+	assert.NoError(err)
+	assert.Equal(1, len(tokens), "Should not overflow the stack on the dropped tokens")
+}
+
+func TestLexer_MaxTokens(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`a b c`)
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w`, "WORD"))
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.MaxTokens = 2
+
+	assert.True(l.Scan())
+	assert.True(l.Scan())
+	assert.False(l.Scan())
+	assert.EqualError(l.Error, "Token limit of 2 is exceeded")
+
+	l.Reset()
+	l.MaxTokens = 3
+	for l.Scan() {
+	}
+	assert.NoError(l.Error, "Should reset the number of tokens")
+}
+
+func TestLexer_EmptyInput(t *testing.T) {
+	assert := assert.New(t)
+
+	calls := 0
+	empty := func(input []byte) (bool, int, interface{}, []byte) {
+		calls++
+		return true, 0, "EMPTY", nil
+	}
+	for _, text := range []string{"", "  \n\t"} {
+		calls = 0
+		l := lexer.NewLexer(text)
+		l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+		l.AddMatcher(lexer.TokenizeIfMatches(`\w*`, "WORD"))
+		l.AddMatcher(empty)
+		assert.False(l.Scan())
+		assert.NoError(l.Error)
+		assert.Equal(0, calls, "Should not call the matchers at the end of input")
+		assert.Equal(len(text), l.Pos())
+	}
+
+	l := lexer.NewLexer("  ")
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddEOFMatcher(func(input []byte) (bool, int, interface{}, []byte) {
+		return true, 0, "EOF", nil
+	})
+	assert.True(l.Scan())
+	assert.Equal("EOF", l.Token().Name)
+	assert.False(l.Scan())
+	assert.NoError(l.Error)
+}
+
+func TestLexer_MatcherResults(t *testing.T) {
+	assert := assert.New(t)
+
+	result := func(matched bool, shift int) lexer.TokenMatcher {
+		return func(input []byte) (bool, int, interface{}, []byte) {
+			if len(input) == 0 {
+				return false, 0, nil, nil
+			}
+			return matched, shift, "NAME", input[:shift]
+		}
+	}
+
+	// token
+	l := lexer.NewLexer(`ab`)
+	l.AddMatcher(result(true, 1))
+	assert.True(l.Scan())
+	assert.Equal("a", string(l.Token().Text))
+
+	// empty token
+	l = lexer.NewLexer(`ab`)
+	l.AddMatcher(result(true, 0))
+	assert.False(l.Scan())
+	assert.EqualError(l.Error, "Matcher 0 matched an empty token at 1:1")
+
+	// skip
+	l = lexer.NewLexer(`ab`)
+	l.AddMatcher(result(false, 1))
+	assert.False(l.Scan())
+	assert.NoError(l.Error)
+	assert.Equal(2, l.Pos())
+	assert.Empty(l.Trivia(), "Should keep the named skips out of trivia")
+
+	// no match
+	l = lexer.NewLexer(`ab`)
+	l.AddMatcher(result(false, 0))
+	l.AddMatcher(result(true, 2))
+	assert.True(l.Scan())
+	assert.Equal(1, l.LastMatcher, "Should try the next matcher")
+	assert.False(l.Scan())
+	assert.NoError(l.Error)
+
+	l = lexer.NewLexer(`ab`)
+	l.AddMatcher(result(false, 0))
+	assert.False(l.Scan())
+	assert.Error(l.Error)
+
+	// EOF matcher
+	l = lexer.NewLexer(``)
+	l.AddEOFMatcher(func(input []byte) (bool, int, interface{}, []byte) {
+		return true, 0, "EOF", nil
+	})
+	assert.True(l.Scan())
+	assert.Equal("EOF", l.Token().Name)
+}
+
+func TestLexer_MatcherShift(t *testing.T) {
+	assert := assert.New(t)
+
+	number := func(over int) lexer.TokenMatcher {
+		return func(input []byte) (bool, int, interface{}, []byte) {
+			n := 0
+			for n < len(input) && input[n] >= '0' && input[n] <= '9' {
+				n++
+			}
+			// a fraction needs a digit after the dot, "1..2" is a range
+			if n > 0 && n+1 < len(input) && input[n] == '.' && input[n+1] >= '0' && input[n+1] <= '9' {
+				for n++; n < len(input) && input[n] >= '0' && input[n] <= '9'; n++ {
+				}
+			}
+			if n == 0 {
+				return false, 0, nil, nil
+			}
+			return true, n + over, "NUMBER", input[:n]
+		}
+	}
+
+	l := lexer.NewLexer("1..2.5")
+	l.AddMatcher(number(0))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\.\.`, "RANGE"))
+	for _, token := range [][]string{{"1", "NUMBER"}, {"..", "RANGE"}, {"2.5", "NUMBER"}} {
+		assert.True(l.Scan())
+		assert.Equal(token[1], l.Token().Name)
+		assert.Equal(token[0], string(l.Token().Raw), "Should consume only the shift")
+	}
+	assert.False(l.Scan())
+	assert.NoError(l.Error)
+
+	l = lexer.NewLexer("1..2")
+	l.AddMatcher(number(1))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\.\.`, "RANGE"))
+	assert.True(l.Scan())
+	assert.Equal("1.", string(l.Token().Raw))
+	assert.NotEqual(len(l.Token().Text), len(l.Token().Raw), "Should expose the over-consumption")
+	assert.False(l.Scan())
+	assert.Error(l.Error, "Should lose the consumed bytes of the next token")
+}
+
+func TestLexer_MatcherOvershift(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer("a b")
+	l.AddMatcher(lexer.TokenizeIfMatches(`a`, "A"))
+	l.AddMatcher(func(input []byte) (bool, int, interface{}, []byte) {
+		return true, len(input) + 1, "B", input
+	})
+	l.AddSkip(lexer.SkipIfMatches(`\s+`))
+
+	assert.True(l.Scan())
+	assert.NotPanics(func() {
+		assert.False(l.Scan())
+	})
+	assert.EqualError(l.Error, "Matcher 1 shift 3 exceeds the remaining input of 2 bytes at 1:2")
+	assert.Equal(1, l.Pos(), "Should not consume the input")
+}
+
+func TestLexer_NoEmptyInputMatch(t *testing.T) {
+	assert := assert.New(t)
+
+	empty := 0
+	strict := func(input []byte) (bool, int, interface{}, []byte) {
+		if len(input) == 0 {
+			empty++
+			return false, 0, nil, nil
+		}
+		if input[0] >= 'a' && input[0] <= 'z' {
+			return true, 1, "CHAR", input[:1]
+		}
+		return false, 0, nil, nil
+	}
+
+	l := lexer.NewLexer("abc")
+	l.AddMatcher(strict)
+	l.AddMergeRule("CHAR")
+	tokens, err := l.ScanAll()
+	assert.NoError(err, "Should not call the matchers with the empty input on merge")
+	assert.Equal(1, len(tokens))
+	assert.Equal("abc", string(tokens[0].Text))
+	assert.Equal(0, empty)
+
+	l = lexer.NewLexer("a")
+	l.AddMatcher(strict)
+	l.AddEOFMatcher(func(input []byte) (bool, int, interface{}, []byte) {
+		return true, 0, "END", nil
+	})
+	assert.True(l.Scan())
+	assert.True(l.Scan())
+	assert.Equal("END", l.Token().Name)
+	assert.NotPanics(func() {
+		assert.Nil(l.MatchingMatchers(), "Should not call the matchers at the end of input")
+	})
+	assert.Equal(0, empty)
+}
+
+func TestLexer_ResetWith(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`foo`)
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	l.Input = "changed"
+	l.Reset()
+	assert.True(l.Scan())
+	assert.Equal("foo", string(l.Token().Text), "Should not re-derive the input from the field")
+	assert.NoError(l.Err())
+
+	l.ResetWith("bar ?")
+	assert.Equal("bar ?", l.Input)
+	assert.True(l.Scan())
+	assert.Equal("bar", string(l.Token().Text))
+	assert.False(l.Scan())
+	assert.Error(l.Err())
+	assert.Equal(l.Error, l.Err())
+
+	matchers := l.Matchers()
+	matchers[0] = nil
+	assert.NotNil(l.Matchers()[0], "Should return a copy of the matchers")
+}
+
+func TestLexer_CountMatchers(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`foo`)
+	assert.Equal(0, l.CountMatchers())
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	l.AddSkip(lexer.SkipIfMatches(`\s+`))
+	l.AddEOFMatcher(func(input []byte) (bool, int, interface{}, []byte) {
+		return true, 0, "EOF", nil
+	})
+	assert.Equal(2, l.CountMatchers())
+}
+
+func TestLexer_InsertMatcher(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`if x`)
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "IDENT"))
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.PrependMatcher(lexer.TokenizeIfMatches(`if\b`, "IF"))
+
+	assert.True(l.Scan())
+	assert.Equal("IF", l.Token().Name)
+	assert.Equal(0, l.LastMatcher)
+	assert.True(l.Scan())
+	assert.Equal("IDENT", l.Token().Name)
+
+	l = lexer.NewLexer(`b`)
+	l.AddMatcherWithPriority(lexer.TokenizeIfMatches(`a`, "HIGH"), 1)
+	l.AddMatcher(lexer.TokenizeIfMatches(`c`, "LOW"))
+	assert.NoError(l.InsertMatcher(1, lexer.TokenizeIfMatches(`b`, "MIDDLE")))
+	assert.Error(l.InsertMatcher(4, lexer.TokenizeIfMatches(`b`, "OUT")))
+	assert.Error(l.InsertMatcher(-1, lexer.TokenizeIfMatches(`b`, "OUT")))
+	assert.Len(l.Matchers(), 3)
+
+	l.AddMatcherWithPriority(lexer.TokenizeIfMatches(`[bc]`, "HIGHER"), 1)
+	assert.True(l.Scan())
+	assert.Equal("HIGHER", l.Token().Name, "Should inherit the priority of the next matcher")
+	assert.Equal(1, l.LastMatcher)
+}
+
+func TestLexer_SaveRestore(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer("foo\nbar ?")
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+
+	assert.True(l.Scan())
+	l.UnScan(lexer.NewToken("SYNTH", nil))
+	state := l.Save()
+
+	for i := 0; i < 2; i++ {
+		assert.True(l.Scan())
+		assert.Equal("SYNTH", l.Token().Name)
+		assert.True(l.Scan())
+		assert.Equal("bar", string(l.Token().Text))
+		assert.Equal(2, l.Token().Line)
+		assert.False(l.Scan())
+		assert.Error(l.Error)
+
+		l.Restore(state)
+		assert.NoError(l.Error, "Should restore the error")
+		assert.Equal("foo", string(l.Token().Text))
+		assert.Equal(3, l.Pos())
+	}
+}
+
+func TestLexer_RestoreTrivia(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer("#a #b #c x #d y")
+	l.AddMatcher(lexer.CollectIfMatches(`#\w`, "COMMENT"))
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w`, "WORD"))
+
+	assert.True(l.Scan())
+	state := l.Save()
+	assert.True(l.Scan())
+	trivia := l.Trivia()
+	assert.Equal(4, len(trivia))
+	comment := trivia[3]
+
+	l.Restore(state)
+	assert.True(l.Scan())
+	assert.Equal(4, len(l.Trivia()))
+	assert.True(comment == trivia[3], "Should not overwrite the returned trivia")
+}
+
+func TestLexer_ScanAfterEnd(t *testing.T) {
+	assert := assert.New(t)
+
+	calls := 0
+	l := lexer.NewLexer(`a ?`)
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w`, "WORD"))
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(func(input []byte) (bool, int, interface{}, []byte) {
+		calls++
+		return false, 0, nil, nil
+	})
+
+	assert.True(l.Scan())
+	assert.False(l.Scan())
+	err := l.Error
+	assert.Error(err)
+	n := calls
+	for i := 0; i < 3; i++ {
+		assert.False(l.Scan())
+	}
+	assert.Equal(n, calls, "Should not scan again")
+	assert.Equal(err, l.Error)
+
+	l.Reset()
+	assert.True(l.Scan(), "Should scan again after reset")
+
+	l = lexer.NewLexer(`a`)
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w`, "WORD"))
+	assert.True(l.Scan())
+	assert.False(l.Scan())
+	assert.False(l.Scan())
+	tok := lexer.NewToken("SYNTH", nil)
+	l.UnScan(tok)
+	assert.True(l.Scan(), "Should return the pushed back tokens")
+	assert.Equal(tok, l.Token())
+	assert.False(l.Scan())
+	assert.NoError(l.Error)
+}
+
+func TestLexer_SkipUntil(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`a = ? b; c; d`)
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	l.AddMatcher(lexer.TokenizeIfMatches(`=`, "ASSIGN"))
+	l.AddMatcher(lexer.TokenizeIfMatches(`;`, "SEMICOLON"))
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+
+	assert.True(l.Scan())
+	assert.True(l.Scan())
+	assert.False(l.Scan())
+	assert.Error(l.Error)
+
+	assert.True(l.SkipUntil("SEMICOLON"), "Should recover from the error")
+	assert.NoError(l.Error)
+	assert.Equal(7, l.Token().Offset)
+	assert.True(l.Scan())
+	assert.Equal("c", string(l.Token().Text))
+
+	assert.True(l.SkipUntil("SEMICOLON", "ASSIGN"))
+	assert.Equal("SEMICOLON", l.Token().Name)
+	assert.False(l.SkipUntil("SEMICOLON"), "Should stop at the end of input")
+	assert.NoError(l.Error)
+}
+
+func TestLexer_ScanLine(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexerFromBytes([]byte("unused"))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+
+	tokens, err := l.ScanLine("foo bar")
+	assert.NoError(err)
+	assert.Len(tokens, 2)
+	assert.Equal("bar", string(tokens[1].Text))
+	assert.Equal(4, tokens[1].Offset)
+
+	tokens, err = l.ScanLine("  baz")
+	assert.NoError(err)
+	assert.Len(tokens, 1)
+	assert.Equal("baz", string(tokens[0].Text))
+	assert.Equal(1, tokens[0].Line)
+	assert.Equal(3, tokens[0].Column)
+	assert.Equal(2, tokens[0].Offset)
+
+	tokens, err = l.ScanLine("a ?")
+	assert.Error(err)
+	assert.Len(tokens, 1)
+	tokens, err = l.ScanLine("b")
+	assert.NoError(err, "Should clear the error")
+	assert.Len(tokens, 1)
+}
+
+func TestLexer_UnScan(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`a b`)
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w`, "WORD"))
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+
+	assert.True(l.Scan())
+	a := l.Token()
+	l.UnScan(a)
+	l.UnScan(lexer.NewToken("SYNTH", []byte("x")))
+
+	assert.True(l.Scan())
+	assert.Equal("SYNTH", l.Token().Name, "Should return the last pushed token first")
+	assert.True(l.Scan())
+	assert.Equal(a, l.Token())
+	assert.True(l.Scan())
+	assert.Equal("b", string(l.Token().Text), "Should continue with the input")
+	assert.False(l.Scan())
+	assert.NoError(l.Error)
+
+	l.Reset()
+	l.UnScan(a)
+	l.Reset()
+	assert.True(l.Scan())
+	assert.Equal("a", string(l.Token().Text), "Should drop the pushed back tokens on reset")
+}
+
+func TestLexer_SetTokenPool(t *testing.T) {
+	assert := assert.New(t)
+
+	pool := &sync.Pool{}
+	l := lexer.NewLexer(`a b`)
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w`, "WORD"))
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.SetTokenPool(pool)
+
+	assert.True(l.Scan())
+	assert.Equal("WORD", l.Token().Name)
+	assert.Equal("a", string(l.Token().Text))
+	assert.True(l.Scan())
+	assert.Equal("b", string(l.Token().Text))
+	tok := l.Token()
+	tok.Release()
+	assert.Nil(tok.Name, "Should clear the released token")
+	assert.Nil(tok.Text)
+
+	unpooled := lexer.NewToken("WORD", []byte("a"))
+	unpooled.Release()
+	assert.Equal("WORD", unpooled.Name, "Should ignore the tokens out of the pool")
+}
+
+// Simple usage example.
+func ExampleNewLexer() {
+	text := `price 12`
+	l := lexer.NewLexer(text)
+	l.AddMatcher(lexer.TokenizeIfMatches(`[a-z]+`, "WORD"))
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\d+`, "PRICE"))
+
+	for l.Scan() {
+		fmt.Printf("%s => %s\n", l.Token().Name, l.Token().Text)
+	}
+
+	// Output:
+	// WORD => price
+	// PRICE => 12
+}
+
+// Using custom matcher to parse the cost and drop the $ sign.
+func ExampleNewLexerWithMatchers() {
+	text := `price $12.4`
+	l := lexer.NewLexerWithMatchers(text, []lexer.TokenMatcher{
+		lexer.TokenizeIfMatches(`\w+`, "WORD"),
+		lexer.SkipIfMatches(`\s+`),
+	})
+	l.AddMatcher(func(input []byte) (matched bool, shift int, tokenName interface{}, tokenText []byte) {
+		// Don't forget to add special symbol '^'
+		re := regexp.MustCompile(`^\$(\d+(?:\.\d+))`)
+		match := re.FindSubmatch(input)
+		if match == nil {
+			return
+		}
+		return true, len(match[0]), "PRICE", match[1]
+	})
+
+	for l.Scan() {
+		fmt.Printf("%s => %s\n", l.Token().Name, l.Token().Text)
+	}
+
+	// Output:
+	// WORD => price
+	// PRICE => 12.4
+}
+
+func benchmarkScan(b *testing.B, pool *sync.Pool) {
+	text := strings.Repeat("foo bar 123 ", 1000)
+	l := lexer.NewLexer(text)
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.SetTokenPool(pool)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.Reset()
+		var prev *lexer.Token
+		for l.Scan() {
+			if prev != nil {
+				prev.Release()
+			}
+			prev = l.Token()
+		}
+	}
+}
+
+func BenchmarkLexer_Scan(b *testing.B) {
+	benchmarkScan(b, nil)
+}
+
+func BenchmarkLexer_ScanTokenPool(b *testing.B) {
+	benchmarkScan(b, &sync.Pool{})
+}
+
+func BenchmarkLexer_ScanInto(b *testing.B) {
+	text := strings.Repeat("foo bar 123 ", 1000)
+	l := lexer.NewLexer(text)
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	b.ReportAllocs()
+	b.ResetTimer()
+	var token lexer.Token
+	for i := 0; i < b.N; i++ {
+		l.Reset()
+		for l.ScanInto(&token) {
+		}
+	}
 }
@@ -0,0 +1,575 @@
+package lexer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+)
+
+// KeywordMatcher creates a matcher of the given keywords, where the map keys
+// are keywords and the values are token names. The longest keyword which
+// isn't followed by an identifier character is matched, so `int` doesn't
+// match the beginning of `internal`.
+//
+//   KeywordMatcher(map[string]interface{}{
+//     "if":   "IF",
+//     "else": "ELSE",
+//     "int":  "INT",
+//   })
+func KeywordMatcher(keywords map[string]interface{}) TokenMatcher {
+	words := make(keywordsByLength, 0, len(keywords))
+	for word := range keywords {
+		if word != "" {
+			words = append(words, []byte(word))
+		}
+	}
+	sort.Sort(words)
+
+	return func(input []byte) (matched bool, shift int, name interface{}, text []byte) {
+		for _, word := range words {
+			if !bytes.HasPrefix(input, word) {
+				continue
+			}
+			if r, size := utf8.DecodeRune(input[len(word):]); size > 0 && isIdentRune(r) {
+				continue
+			}
+			return true, len(word), keywords[string(word)], input[:len(word)]
+		}
+		return
+	}
+}
+
+// TokenizeBalanced creates token with given name of the span enclosed in
+// the balanced open and close delimiters, including the nested pairs.
+// It doesn't match if the delimiters are unbalanced before the end of input.
+//
+//   TokenizeBalanced('(', ')', "GROUP") // matches "(a (b) c)" as a whole
+func TokenizeBalanced(open, close byte, tokenName interface{}) TokenMatcher {
+	return func(input []byte) (matched bool, shift int, name interface{}, text []byte) {
+		if len(input) == 0 || input[0] != open {
+			return
+		}
+		depth := 0
+		for i, c := range input {
+			switch c {
+			case open:
+				depth++
+			case close:
+				depth--
+			}
+			if depth == 0 {
+				return true, i + 1, tokenName, input[:i+1]
+			}
+		}
+		return
+	}
+}
+
+// TokenizeQuoted creates token with given name of the string enclosed in
+// the quote characters. Backslash escapes the next character, so `\"` and
+// `\\` don't terminate the string. The token's body is the content between
+// the quotes with escapes left as is, and Raw is the whole quoted string.
+// It doesn't match unterminated strings.
+//
+//   TokenizeQuoted('"', "STRING") // matches `"say \"hi\""`
+func TokenizeQuoted(quote byte, tokenName interface{}) TokenMatcher {
+	return func(input []byte) (matched bool, shift int, name interface{}, text []byte) {
+		if len(input) == 0 || input[0] != quote {
+			return
+		}
+		for i := 1; i < len(input); i++ {
+			switch input[i] {
+			case '\\':
+				i++
+			case quote:
+				return true, i + 1, tokenName, input[1:i]
+			}
+		}
+		return
+	}
+}
+
+// TokenizeAnyRune creates token with given name of any single rune, or
+// a single byte of invalid UTF-8. Added as the last matcher it makes
+// a catch-all, so Scan doesn't fail on unexpected characters.
+func TokenizeAnyRune(tokenName interface{}) TokenMatcher {
+	return func(input []byte) (matched bool, shift int, name interface{}, text []byte) {
+		if len(input) == 0 {
+			return
+		}
+		_, size := utf8.DecodeRune(input)
+		return true, size, tokenName, input[:size]
+	}
+}
+
+// TokenizeCharEscape creates token with given name of the backslash escape
+// sequence like `\n`, `\xFF`, `\u00e9` or `\U0001F600` using Go's syntax.
+// The token's Value is the decoded rune and Raw is the escape text. Unknown
+// escapes stop scanning with the error, see TokenizeCharEscapeLenient.
+//
+//   TokenizeCharEscape("ESCAPE") // `\t` has the '\t' value
+func TokenizeCharEscape(tokenName interface{}) TokenMatcher {
+	return tokenizeCharEscape(tokenName, false)
+}
+
+// TokenizeCharEscapeLenient is the same as TokenizeCharEscape, but unknown
+// escapes produce the token of the escaped character itself, so `\q` has
+// the 'q' value.
+func TokenizeCharEscapeLenient(tokenName interface{}) TokenMatcher {
+	return tokenizeCharEscape(tokenName, true)
+}
+
+// tokenizeCharEscape creates token of the escape sequence, which is
+// the escaped character if the sequence is unknown and lenient is set.
+func tokenizeCharEscape(tokenName interface{}, lenient bool) TokenMatcher {
+	return func(input []byte) (matched bool, shift int, name interface{}, text []byte) {
+		if len(input) < 2 || input[0] != '\\' {
+			return
+		}
+		// the longest escape is \U and 8 hex digits
+		s := input
+		if len(s) > 10 {
+			s = s[:10]
+		}
+		var quote byte
+		if input[1] == '\'' || input[1] == '"' {
+			quote = input[1]
+		}
+		value, _, tail, err := strconv.UnquoteChar(string(s), quote)
+		n := len(s) - len(tail)
+		if err != nil {
+			var size int
+			value, size = utf8.DecodeRune(input[1:])
+			n = 1 + size
+			if !lenient {
+				return true, n, errors.New(fmt.Sprintf(escapeErrorMessage, input[:n])), input[:n]
+			}
+		}
+		return true, n, &Token{Name: tokenName, Text: input[:n], Value: value}, input[:n]
+	}
+}
+
+// SkipBetween creates a matcher skipping the text which starts with start
+// and ends with end, like block comments. If nested is set, the inner pairs
+// of the delimiters are honored. The unterminated text stops scanning with
+// the error, see SkipBetweenOrEOF.
+//
+//   SkipBetween("/*", "*/", false)
+func SkipBetween(start, end string, nested bool) TokenMatcher {
+	return skipBetween([]byte(start), []byte(end), nested, false)
+}
+
+// SkipBetweenOrEOF is the same as SkipBetween, but it skips the rest of
+// the input if the text isn't terminated.
+func SkipBetweenOrEOF(start, end string, nested bool) TokenMatcher {
+	return skipBetween([]byte(start), []byte(end), nested, true)
+}
+
+// skipBetween creates a matcher skipping the delimited text, which is
+// skipped to the end of input if it's unterminated and orEOF is set.
+func skipBetween(start, end []byte, nested, orEOF bool) TokenMatcher {
+	return func(input []byte) (matched bool, shift int, name interface{}, text []byte) {
+		if len(start) == 0 || !bytes.HasPrefix(input, start) {
+			return
+		}
+		depth := 1
+		for n := len(start); n < len(input); {
+			switch {
+			case len(end) > 0 && bytes.HasPrefix(input[n:], end):
+				n += len(end)
+				if depth--; depth == 0 {
+					return false, n, nil, nil
+				}
+			case nested && bytes.HasPrefix(input[n:], start):
+				n += len(start)
+				depth++
+			default:
+				n++
+			}
+		}
+		if orEOF {
+			return false, len(input), nil, nil
+		}
+		return true, 0, errors.New(fmt.Sprintf(unterminatedErrorMessage, start, end)), nil
+	}
+}
+
+// TokenizeUntil creates token with given name of the input up to
+// the terminator, which is included in the token if includeTerminator is set.
+// It doesn't match if the terminator isn't found, see TokenizeUntilOrEOF.
+// It's useful for heredocs and raw blocks.
+//
+//   TokenizeUntil("EOT", "HEREDOC", false)
+func TokenizeUntil(terminator string, tokenName interface{}, includeTerminator bool) TokenMatcher {
+	return tokenizeUntil([]byte(terminator), tokenName, includeTerminator, false)
+}
+
+// TokenizeUntilOrEOF is the same as TokenizeUntil, but it consumes the rest
+// of the input if the terminator isn't found.
+func TokenizeUntilOrEOF(terminator string, tokenName interface{}, includeTerminator bool) TokenMatcher {
+	return tokenizeUntil([]byte(terminator), tokenName, includeTerminator, true)
+}
+
+// TokenizeUntilUnescaped creates token with given name of the input up to
+// the first terminator which isn't preceded by the escape character.
+// The escape character escapes itself as well, so in `a\\"` the quote is
+// the terminator. The terminator isn't included in the token and it doesn't
+// match if there is no unescaped terminator.
+//
+//   TokenizeUntilUnescaped('"', '\\', "CONTENT") // matches `say \"hi\"` of `say \"hi\""`
+func TokenizeUntilUnescaped(terminator, escape byte, tokenName interface{}) TokenMatcher {
+	return func(input []byte) (matched bool, shift int, name interface{}, text []byte) {
+		for n := 0; n < len(input); n++ {
+			switch input[n] {
+			case escape:
+				n++
+			case terminator:
+				if n == 0 {
+					return
+				}
+				return true, n, tokenName, input[:n]
+			}
+		}
+		return
+	}
+}
+
+// tokenizeUntil creates token of the input up to the terminator or
+// the end of input if orEOF is set.
+func tokenizeUntil(terminator []byte, tokenName interface{}, includeTerminator, orEOF bool) TokenMatcher {
+	return func(input []byte) (matched bool, shift int, name interface{}, text []byte) {
+		n := bytes.Index(input, terminator)
+		if n < 0 && !orEOF {
+			return
+		}
+		if n < 0 {
+			n = len(input)
+		} else if includeTerminator {
+			n += len(terminator)
+		}
+		if n == 0 {
+			return
+		}
+		return true, n, tokenName, input[:n]
+	}
+}
+
+// TokenizeUnicodeClass creates token with given name of the longest run of
+// the runes of the class, e.g. unicode.IsLetter. It's faster than the regexp
+// classes like `\pL+` and doesn't need escaping.
+//
+//   TokenizeUnicodeClass(unicode.IsUpper, "UPPER")
+func TokenizeUnicodeClass(class func(rune) bool, tokenName interface{}) TokenMatcher {
+	return func(input []byte) (matched bool, shift int, name interface{}, text []byte) {
+		if n := runesOf(class, input); n > 0 {
+			return true, n, tokenName, input[:n]
+		}
+		return
+	}
+}
+
+// LetterMatcher creates token with given name of the Unicode letters.
+func LetterMatcher(tokenName interface{}) TokenMatcher {
+	return TokenizeUnicodeClass(unicode.IsLetter, tokenName)
+}
+
+// DigitMatcher creates token with given name of the Unicode decimal digits.
+func DigitMatcher(tokenName interface{}) TokenMatcher {
+	return TokenizeUnicodeClass(unicode.IsDigit, tokenName)
+}
+
+// WhitespaceSkip skips the Unicode whitespace.
+func WhitespaceSkip() TokenMatcher {
+	return func(input []byte) (matched bool, shift int, name interface{}, text []byte) {
+		return false, runesOf(unicode.IsSpace, input), nil, nil
+	}
+}
+
+// runesOf returns the length in bytes of the longest prefix of the input
+// consisting of the runes of the class.
+func runesOf(class func(rune) bool, input []byte) int {
+	n := 0
+	for n < len(input) {
+		r, size := utf8.DecodeRune(input[n:])
+		if !class(r) {
+			break
+		}
+		n += size
+	}
+	return n
+}
+
+// NumberOptions enables the numeric literals' forms, see NumberMatcher.
+type NumberOptions struct {
+	Hex         bool // hexadecimal integers with 0x prefix
+	Octal       bool // octal integers with 0o prefix
+	Binary      bool // binary integers with 0b prefix
+	Float       bool // decimal fractions and exponents like 1.5e-3
+	Underscores bool // underscores between digits like 1_000
+}
+
+// NumberMatcher creates token with given name of a numeric literal, which is
+// a decimal integer and the forms enabled by the options. The token's Value
+// is the parsed number: int64 for integers and float64 for floats. An integer
+// which overflows int64 stops scanning with the parse error.
+//
+//   NumberMatcher("NUMBER", NumberOptions{Hex: true, Float: true})
+func NumberMatcher(tokenName interface{}, opts NumberOptions) TokenMatcher {
+	return func(input []byte) (matched bool, shift int, name interface{}, text []byte) {
+		n, base, float := scanNumber(input, opts)
+		if n == 0 {
+			return
+		}
+		digits := input[:n]
+		if base != 10 {
+			digits = digits[2:]
+		}
+		if opts.Underscores {
+			digits = bytes.Replace(digits, []byte("_"), nil, -1)
+		}
+		var value interface{}
+		var err error
+		if float {
+			value, err = strconv.ParseFloat(string(digits), 64)
+		} else {
+			value, err = strconv.ParseInt(string(digits), base, 64)
+		}
+		if err != nil {
+			return true, n, err, input[:n]
+		}
+		token := NewToken(tokenName, input[:n])
+		token.Value = value
+		return true, n, token, input[:n]
+	}
+}
+
+// scanNumber returns the length of the numeric literal at the beginning of
+// the input, its base and whether it's a float.
+func scanNumber(input []byte, opts NumberOptions) (n, base int, float bool) {
+	if len(input) > 2 && input[0] == '0' {
+		prefixes := []struct {
+			enabled bool
+			char    byte
+			base    int
+		}{{opts.Hex, 'x', 16}, {opts.Octal, 'o', 8}, {opts.Binary, 'b', 2}}
+		for _, p := range prefixes {
+			if p.enabled && input[1]|0x20 == p.char {
+				if n := digitsOf(input[2:], p.base, opts.Underscores); n > 0 {
+					return n + 2, p.base, false
+				}
+			}
+		}
+	}
+
+	n = digitsOf(input, 10, opts.Underscores)
+	if n == 0 || !opts.Float {
+		return n, 10, false
+	}
+	if n < len(input) && input[n] == '.' {
+		if m := digitsOf(input[n+1:], 10, opts.Underscores); m > 0 {
+			n, float = n+1+m, true
+		}
+	}
+	if n < len(input) && input[n]|0x20 == 'e' {
+		i := n + 1
+		if i < len(input) && (input[i] == '+' || input[i] == '-') {
+			i++
+		}
+		if m := digitsOf(input[i:], 10, opts.Underscores); m > 0 {
+			n, float = i+m, true
+		}
+	}
+	return n, 10, float
+}
+
+// digitsOf returns the length of the digits of given base at the beginning
+// of the input. The underscores are allowed between the digits if enabled.
+func digitsOf(input []byte, base int, underscores bool) int {
+	isDigit := func(i int) bool {
+		if i >= len(input) {
+			return false
+		}
+		d := -1
+		switch c := input[i]; {
+		case c >= '0' && c <= '9':
+			d = int(c - '0')
+		case c|0x20 >= 'a' && c|0x20 <= 'f':
+			d = int(c|0x20-'a') + 10
+		}
+		return d >= 0 && d < base
+	}
+	n := 0
+	for {
+		if isDigit(n) {
+			n++
+		} else if underscores && n > 0 && n < len(input) && input[n] == '_' && isDigit(n+1) {
+			n += 2
+		} else {
+			return n
+		}
+	}
+}
+
+// NewlineMatcher creates token with given name of a line break, which is
+// "\n", "\r\n" or "\r". It's useful for line-oriented grammars, where
+// the other whitespace is skipped.
+//
+//   l.AddMatcher(NewlineMatcher("NEWLINE"))
+//   l.AddMatcher(SkipIfMatches(`[ \t]+`))
+func NewlineMatcher(tokenName interface{}) TokenMatcher {
+	return func(input []byte) (matched bool, shift int, name interface{}, text []byte) {
+		switch {
+		case bytes.HasPrefix(input, []byte("\r\n")):
+			return true, 2, tokenName, input[:2]
+		case len(input) > 0 && (input[0] == '\n' || input[0] == '\r'):
+			return true, 1, tokenName, input[:1]
+		}
+		return
+	}
+}
+
+// DispatchByFirstByte creates a matcher which runs only the matchers
+// registered in the table for the first byte of the input, and then
+// the fallback ones. The first matcher which matches or skips the input wins.
+// It's faster than trying many matchers in turn, e.g. for punctuation tokens.
+//
+//   DispatchByFirstByte(map[byte][]TokenMatcher{
+//     '+': {TokenizeIfMatches(`\+\+`, "INC"), TokenizeIfMatches(`\+`, "PLUS")},
+//     '-': {TokenizeIfMatches(`-`, "MINUS")},
+//   }, []TokenMatcher{TokenizeIfMatches(`\w+`, "WORD")})
+func DispatchByFirstByte(table map[byte][]TokenMatcher, fallback []TokenMatcher) TokenMatcher {
+	return func(input []byte) (matched bool, shift int, name interface{}, text []byte) {
+		if len(input) == 0 {
+			return
+		}
+		for _, matchers := range [][]TokenMatcher{table[input[0]], fallback} {
+			for _, fn := range matchers {
+				if matched, shift, name, text = fn(input); matched || shift > 0 {
+					return
+				}
+			}
+		}
+		return false, 0, nil, nil
+	}
+}
+
+// LiteralTrie creates a matcher of the given literals, where the map keys
+// are literals and the values are token names. The longest literal is matched,
+// so their order doesn't matter. Unlike KeywordMatcher, it doesn't check
+// the identifier boundary, which suits operators and punctuation.
+//
+//   LiteralTrie(map[string]interface{}{
+//     "<":   "LT",
+//     "<=":  "LE",
+//     "<<":  "SHL",
+//     "<<=": "SHL_ASSIGN",
+//   })
+func LiteralTrie(literals map[string]interface{}) TokenMatcher {
+	root := &trieNode{}
+	for literal, name := range literals {
+		if literal != "" {
+			root.insert(literal, name)
+		}
+	}
+
+	return func(input []byte) (matched bool, shift int, name interface{}, text []byte) {
+		node := root
+		for i, c := range input {
+			if node = node.next[c]; node == nil {
+				break
+			}
+			if node.end {
+				matched, shift, name = true, i+1, node.name
+			}
+		}
+		if !matched {
+			return
+		}
+		return true, shift, name, input[:shift]
+	}
+}
+
+// Or creates a matcher which tries the matchers in turn and returns
+// the result of the first one which matches or skips the input.
+//
+//   Or(KeywordMatcher(keywords), TokenizeIfMatches(`\w+`, "IDENT"))
+func Or(matchers ...TokenMatcher) TokenMatcher {
+	return func(input []byte) (matched bool, shift int, name interface{}, text []byte) {
+		for _, fn := range matchers {
+			if matched, shift, name, text = fn(input); matched || shift > 0 {
+				return
+			}
+		}
+		return false, 0, nil, nil
+	}
+}
+
+// Seq creates token with given name spanning the consecutive matches of
+// all the matchers. A matcher may match the empty string, e.g. an optional
+// part, but the whole sequence must consume some input. An error of
+// a matcher is returned as the result of the sequence.
+//
+//   Seq("INT", TokenizeIfMatches(`[+-]?`, nil), TokenizeIfMatches(`\d+`, nil))
+func Seq(tokenName interface{}, matchers ...TokenMatcher) TokenMatcher {
+	return func(input []byte) (matched bool, shift int, name interface{}, text []byte) {
+		n := 0
+		for _, fn := range matchers {
+			ok, size, subName, _ := fn(input[n:])
+			if err, isError := subName.(error); isError {
+				return true, n + size, err, nil
+			}
+			if !ok && size == 0 {
+				return
+			}
+			n += size
+		}
+		if n == 0 {
+			return
+		}
+		return true, n, tokenName, input[:n]
+	}
+}
+
+// isIdentRune checks whether the rune can be a part of an identifier.
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// trieNode is the node of the literals' trie, see LiteralTrie.
+type trieNode struct {
+	next map[byte]*trieNode // child nodes by the next byte
+	name interface{}        // token name of the literal ending here
+	end  bool               // whether a literal ends here
+}
+
+// insert adds the literal with given token name to the trie.
+func (n *trieNode) insert(literal string, name interface{}) {
+	for i := 0; i < len(literal); i++ {
+		if n.next == nil {
+			n.next = make(map[byte]*trieNode)
+		}
+		child, ok := n.next[literal[i]]
+		if !ok {
+			child = &trieNode{}
+			n.next[literal[i]] = child
+		}
+		n = child
+	}
+	n.name, n.end = name, true
+}
+
+// keywordsByLength sorts keywords from the longest to the shortest one.
+type keywordsByLength [][]byte
+
+func (k keywordsByLength) Len() int      { return len(k) }
+func (k keywordsByLength) Swap(i, j int) { k[i], k[j] = k[j], k[i] }
+func (k keywordsByLength) Less(i, j int) bool {
+	if len(k[i]) != len(k[j]) {
+		return len(k[i]) > len(k[j])
+	}
+	return bytes.Compare(k[i], k[j]) < 0
+}
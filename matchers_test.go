@@ -0,0 +1,459 @@
+package lexer_test
+
+import (
+	"testing"
+	"unicode"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zoer/lexer"
+)
+
+func TestKeywordMatcher(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`int internal in inx if_ if`)
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.KeywordMatcher(map[string]interface{}{
+		"int": "INT",
+		"in":  "IN",
+		"if":  "IF",
+	}))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "IDENT"))
+
+	for _, token := range [][]string{
+		{"int", "INT"},
+		{"internal", "IDENT"},
+		{"in", "IN"},
+		{"inx", "IDENT"},
+		{"if_", "IDENT"},
+		{"if", "IF"},
+	} {
+		assert.True(l.Scan())
+		assert.Equal(token[1], l.Token().Name)
+		assert.Equal([]byte(token[0]), l.Token().Text)
+	}
+	assert.False(l.Scan())
+	assert.NoError(l.Error)
+}
+
+func TestTokenizeBalanced(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`f(a, (b), (c (d)))(x) (y`)
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "IDENT"))
+	l.AddMatcher(lexer.TokenizeBalanced('(', ')', "GROUP"))
+
+	for _, token := range [][]string{
+		{"f", "IDENT"},
+		{"(a, (b), (c (d)))", "GROUP"},
+		{"(x)", "GROUP"},
+	} {
+		assert.True(l.Scan())
+		assert.Equal(token[1], l.Token().Name)
+		assert.Equal([]byte(token[0]), l.Token().Text)
+	}
+	assert.False(l.Scan())
+	assert.Error(l.Error, "Should not match unbalanced delimiters")
+}
+
+func TestTokenizeQuoted(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`"foo" "say \"hi\"" "a\\" "" 'x' "open`)
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.TokenizeQuoted('"', "STRING"))
+	l.AddMatcher(lexer.TokenizeQuoted('\'', "CHAR"))
+
+	for _, token := range [][]string{
+		{`foo`, `"foo"`, "STRING"},
+		{`say \"hi\"`, `"say \"hi\""`, "STRING"},
+		{`a\\`, `"a\\"`, "STRING"},
+		{``, `""`, "STRING"},
+		{`x`, `'x'`, "CHAR"},
+	} {
+		assert.True(l.Scan())
+		assert.Equal(token[2], l.Token().Name)
+		assert.Equal([]byte(token[0]), l.Token().Text)
+		assert.Equal([]byte(token[1]), l.Token().Raw)
+	}
+	assert.False(l.Scan())
+	assert.Error(l.Error, "Should not match unterminated string")
+}
+
+func TestTokenizeAnyRune(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexerFromBytes([]byte("ab\xffя+"))
+	l.AddMatcher(lexer.TokenizeIfMatches(`[a-z]+`, "WORD"))
+	l.AddMatcher(lexer.TokenizeAnyRune("ANY"))
+
+	for _, token := range [][]string{
+		{"ab", "WORD"},
+		{"\xff", "ANY"},
+		{"я", "ANY"},
+		{"+", "ANY"},
+	} {
+		assert.True(l.Scan())
+		assert.Equal(token[1], l.Token().Name)
+		assert.Equal([]byte(token[0]), l.Token().Text)
+	}
+	assert.False(l.Scan())
+	assert.NoError(l.Error)
+}
+
+func TestDispatchByFirstByte(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`a++ -b+ c`)
+	l.AddMatcher(lexer.DispatchByFirstByte(map[byte][]lexer.TokenMatcher{
+		'+': {lexer.TokenizeIfMatches(`\+\+`, "INC"), lexer.TokenizeIfMatches(`\+`, "PLUS")},
+		'-': {lexer.TokenizeIfMatches(`-`, "MINUS")},
+		' ': {lexer.SkipIfMatches(` +`)},
+	}, []lexer.TokenMatcher{
+		lexer.TokenizeIfMatches(`\w+`, "WORD"),
+	}))
+
+	for _, token := range [][]string{
+		{"a", "WORD"},
+		{"++", "INC"},
+		{"-", "MINUS"},
+		{"b", "WORD"},
+		{"+", "PLUS"},
+		{"c", "WORD"},
+	} {
+		assert.True(l.Scan())
+		assert.Equal(token[1], l.Token().Name)
+		assert.Equal([]byte(token[0]), l.Token().Text)
+	}
+	assert.False(l.Scan())
+	assert.NoError(l.Error)
+
+	l = lexer.NewLexer(`?`)
+	l.AddMatcher(lexer.DispatchByFirstByte(nil, nil))
+	assert.False(l.Scan())
+	assert.Error(l.Error, "Should not match without matchers")
+}
+
+func TestNewlineMatcher(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer("mov a\r\n\tret \nnop\r")
+	l.AddMatcher(lexer.NewlineMatcher("NEWLINE"))
+	l.AddMatcher(lexer.SkipIfMatches(`[ \t]+`))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+
+	for _, token := range []struct {
+		text string
+		name string
+		line int
+	}{
+		{"mov", "WORD", 1},
+		{"a", "WORD", 1},
+		{"\r\n", "NEWLINE", 1},
+		{"ret", "WORD", 2},
+		{"\n", "NEWLINE", 2},
+		{"nop", "WORD", 3},
+		{"\r", "NEWLINE", 3},
+	} {
+		assert.True(l.Scan())
+		assert.Equal(token.name, l.Token().Name)
+		assert.Equal([]byte(token.text), l.Token().Text)
+		assert.Equal(token.line, l.Token().Line)
+	}
+	assert.False(l.Scan())
+	assert.NoError(l.Error)
+}
+
+func TestOr(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`if x 1`)
+	l.AddMatcher(lexer.Or(
+		lexer.SkipIfMatches(`\s+`),
+		lexer.TokenizeIfMatches(`if\b`, "IF"),
+		lexer.TokenizeIfMatches(`\w+`, "IDENT"),
+	))
+	l.AddMatcher(lexer.TokenizeIfMatches(`.`, "CHAR"))
+
+	for _, name := range []string{"IF", "IDENT", "IDENT"} {
+		assert.True(l.Scan())
+		assert.Equal(name, l.Token().Name)
+		assert.Equal(0, l.LastMatcher)
+	}
+	assert.False(l.Scan())
+	assert.NoError(l.Error)
+}
+
+func TestSeq(t *testing.T) {
+	assert := assert.New(t)
+
+	number := lexer.Seq("INT",
+		lexer.TokenizeIfMatches(`[+-]?`, nil),
+		lexer.TokenizeIfMatches(`\d+`, nil),
+	)
+	l := lexer.NewLexer(`-12 7 +`)
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(number)
+	l.AddMatcher(lexer.TokenizeIfMatches(`[+-]`, "SIGN"))
+
+	for _, token := range [][]string{
+		{"-12", "INT"},
+		{"7", "INT"},
+		{"+", "SIGN"},
+	} {
+		assert.True(l.Scan())
+		assert.Equal(token[1], l.Token().Name)
+		assert.Equal([]byte(token[0]), l.Token().Text)
+	}
+	assert.False(l.Scan())
+	assert.NoError(l.Error)
+
+	l = lexer.NewLexer(`x`)
+	l.AddMatcher(lexer.Seq("EMPTY", lexer.TokenizeIfMatches(`y?`, nil)))
+	assert.False(l.Scan())
+	assert.Error(l.Error, "Should not match the empty sequence")
+
+	l = lexer.NewLexer(`-99999999999999999999`)
+	l.AddMatcher(lexer.Seq("INT",
+		lexer.TokenizeIfMatches(`[+-]?`, nil),
+		lexer.NumberMatcher(nil, lexer.NumberOptions{}),
+	))
+	assert.False(l.Scan())
+	assert.Error(l.Error, "Should return the matcher's error")
+	assert.Contains(l.Error.Error(), "out of range")
+}
+
+func TestLiteralTrie(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`<<= < <= << <a`)
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.LiteralTrie(map[string]interface{}{
+		"<":   "LT",
+		"<=":  "LE",
+		"<<":  "SHL",
+		"<<=": "SHL_ASSIGN",
+		"":    "EMPTY",
+	}))
+
+	for _, token := range [][]string{
+		{"<<=", "SHL_ASSIGN"},
+		{"<", "LT"},
+		{"<=", "LE"},
+		{"<<", "SHL"},
+		{"<", "LT"},
+	} {
+		assert.True(l.Scan())
+		assert.Equal(token[1], l.Token().Name)
+		assert.Equal([]byte(token[0]), l.Token().Text)
+	}
+	assert.False(l.Scan())
+	assert.Error(l.Error, "Should not match unknown literals")
+}
+
+func TestTokenizeUnicodeClass(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer("Цена\u00a0 ٣12 ABc")
+	l.AddMatcher(lexer.WhitespaceSkip())
+	l.AddMatcher(lexer.TokenizeUnicodeClass(unicode.IsUpper, "UPPER"))
+	l.AddMatcher(lexer.LetterMatcher("LETTERS"))
+	l.AddMatcher(lexer.DigitMatcher("DIGITS"))
+
+	for _, token := range [][]string{
+		{"Ц", "UPPER"},
+		{"ена", "LETTERS"},
+		{"٣12", "DIGITS"},
+		{"AB", "UPPER"},
+		{"c", "LETTERS"},
+	} {
+		assert.True(l.Scan())
+		assert.Equal(token[1], l.Token().Name)
+		assert.Equal([]byte(token[0]), l.Token().Text)
+	}
+	assert.False(l.Scan())
+	assert.NoError(l.Error)
+}
+
+func TestNumberMatcher(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`12 0x1F 0o17 0b101 1_000 1.5 2e3 1.5E-2 0xZ 3. 1__0 4_`)
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.NumberMatcher("NUMBER", lexer.NumberOptions{
+		Hex:         true,
+		Octal:       true,
+		Binary:      true,
+		Float:       true,
+		Underscores: true,
+	}))
+	l.AddMatcher(lexer.TokenizeIfMatches(`[^\s\d]+`, "OTHER"))
+
+	for _, token := range []struct {
+		text  string
+		value interface{}
+	}{
+		{"12", int64(12)},
+		{"0x1F", int64(31)},
+		{"0o17", int64(15)},
+		{"0b101", int64(5)},
+		{"1_000", int64(1000)},
+		{"1.5", 1.5},
+		{"2e3", 2000.0},
+		{"1.5E-2", 0.015},
+		{"0", int64(0)},
+		{"xZ", nil},
+		{"3", int64(3)},
+		{".", nil},
+		{"1", int64(1)},
+		{"__", nil},
+		{"0", int64(0)},
+		{"4", int64(4)},
+		{"_", nil},
+	} {
+		assert.True(l.Scan())
+		assert.Equal(token.text, string(l.Token().Text))
+		assert.Equal(token.value, l.Token().Value)
+	}
+	assert.False(l.Scan())
+	assert.NoError(l.Error)
+
+	l = lexer.NewLexer(`0x1F 1.5`)
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.NumberMatcher("NUMBER", lexer.NumberOptions{}))
+	l.AddMatcher(lexer.TokenizeIfMatches(`[^\s\d]+`, "OTHER"))
+	var texts []string
+	for l.Scan() {
+		texts = append(texts, string(l.Token().Text))
+	}
+	assert.Equal([]string{"0", "x", "1", "F", "1", ".", "5"}, texts, "Should match the decimal integers only")
+
+	l = lexer.NewLexer(`99999999999999999999`)
+	l.AddMatcher(lexer.NumberMatcher("NUMBER", lexer.NumberOptions{}))
+	assert.False(l.Scan())
+	assert.Error(l.Error, "Should fail on overflow")
+}
+
+func TestTokenizeUntil(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer("<<EOT\na\nb\nEOT\n<<EOT\nc")
+	l.AddMatcher(lexer.TokenizeIfMatches(`<<EOT\n`, "START"))
+	l.AddMatcher(lexer.TokenizeUntil("EOT", "BODY", false))
+	l.AddMatcher(lexer.TokenizeIfMatches(`EOT\n?`, "END"))
+
+	for _, token := range [][]string{
+		{"<<EOT\n", "START"},
+		{"a\nb\n", "BODY"},
+		{"EOT\n", "END"},
+		{"<<EOT\n", "START"},
+	} {
+		assert.True(l.Scan())
+		assert.Equal(token[1], l.Token().Name)
+		assert.Equal([]byte(token[0]), l.Token().Text)
+	}
+	assert.False(l.Scan())
+	assert.Error(l.Error, "Should not match without the terminator")
+
+	l = lexer.NewLexer("a;b")
+	l.AddMatcher(lexer.TokenizeUntilOrEOF(";", "STMT", true))
+	assert.True(l.Scan())
+	assert.Equal("a;", string(l.Token().Text), "Should include the terminator")
+	assert.True(l.Scan())
+	assert.Equal("b", string(l.Token().Text), "Should consume up to the end of input")
+	assert.False(l.Scan())
+	assert.NoError(l.Error)
+}
+
+func TestTokenizeCharEscape(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer(`\n\t\xFF\u00e9\"\q`)
+	l.AddMatcher(lexer.TokenizeCharEscape("ESCAPE"))
+
+	for _, token := range []struct {
+		raw   string
+		value rune
+	}{
+		{`\n`, '\n'},
+		{`\t`, '\t'},
+		{`\xFF`, 0xFF},
+		{`\u00e9`, 'é'},
+		{`\"`, '"'},
+	} {
+		assert.True(l.Scan())
+		assert.Equal("ESCAPE", l.Token().Name)
+		assert.Equal(token.value, l.Token().Value)
+		assert.Equal(token.raw, string(l.Token().Raw))
+	}
+	assert.False(l.Scan())
+	assert.EqualError(l.Error, `Unknown escape sequence "\\q"`)
+
+	l = lexer.NewLexer(`\q\x`)
+	l.AddMatcher(lexer.TokenizeCharEscapeLenient("ESCAPE"))
+	assert.True(l.Scan())
+	assert.Equal('q', l.Token().Value, "Should produce the escaped character")
+	assert.True(l.Scan())
+	assert.Equal('x', l.Token().Value)
+	assert.Equal(`\x`, string(l.Token().Raw))
+	assert.False(l.Scan())
+	assert.NoError(l.Error)
+}
+
+func TestSkipBetween(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer("a/* b */c/* /* d */ */e")
+	l.AddMatcher(lexer.TokenizeIfMatches(`[a-z]`, "WORD"))
+	l.AddMatcher(lexer.SkipBetween("/*", "*/", true))
+	tokens, err := l.ScanAll()
+	assert.NoError(err)
+	assert.Equal(3, len(tokens))
+	assert.Equal("e", string(tokens[2].Text), "Should honor the nested comments")
+
+	l = lexer.NewLexer("a/* /* b */c")
+	l.AddMatcher(lexer.TokenizeIfMatches(`[a-z]`, "WORD"))
+	l.AddMatcher(lexer.SkipBetween("/*", "*/", false))
+	tokens, err = l.ScanAll()
+	assert.NoError(err)
+	assert.Equal("c", string(tokens[1].Text))
+
+	l = lexer.NewLexer("a/* b")
+	l.AddMatcher(lexer.TokenizeIfMatches(`[a-z]`, "WORD"))
+	l.AddMatcher(lexer.SkipBetween("/*", "*/", true))
+	_, err = l.ScanAll()
+	assert.EqualError(err, `"/*" is not terminated with "*/"`)
+
+	l = lexer.NewLexer("a/* b")
+	l.AddMatcher(lexer.TokenizeIfMatches(`[a-z]`, "WORD"))
+	l.AddMatcher(lexer.SkipBetweenOrEOF("/*", "*/", true))
+	tokens, err = l.ScanAll()
+	assert.NoError(err)
+	assert.Equal(1, len(tokens), "Should skip to the end of input")
+}
+
+func TestTokenizeUntilUnescaped(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, test := range []struct {
+		input, text string
+	}{
+		{`say \"hi\"" rest`, `say \"hi\"`},
+		{`a\\" rest`, `a\\`},
+		{`a\`, ``},
+		{`abc`, ``},
+		{`"abc`, ``},
+	} {
+		matched, shift, name, text := lexer.TokenizeUntilUnescaped('"', '\\', "CONTENT")([]byte(test.input))
+		if test.text == "" {
+			assert.False(matched, test.input)
+			assert.Equal(0, shift, test.input)
+			continue
+		}
+		assert.True(matched, test.input)
+		assert.Equal(len(test.text), shift, test.input)
+		assert.Equal("CONTENT", name)
+		assert.Equal(test.text, string(text))
+	}
+}
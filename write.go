@@ -0,0 +1,159 @@
+package lexer
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// jsonToken is the token's JSON representation, see WriteJSON.
+type jsonToken struct {
+	Name interface{} `json:"name"`
+	Text string      `json:"text"`
+	Line int         `json:"line"`
+	Col  int         `json:"col"`
+}
+
+// WriteTokens scans the input to the end and writes each token to w as
+// a line like `LINE:COL NAME "text"`. It returns the scanning error if any.
+//
+//   l.WriteTokens(os.Stdout)
+//   // 1:1 WORD "price"
+//   // 1:7 PRICE "12"
+func (l *Lexer) WriteTokens(w io.Writer) error {
+	for l.Scan() {
+		t := l.Token()
+		if _, err := fmt.Fprintf(w, "%d:%d %s %q\n", t.Line, t.Column, l.nameString(t.Name), t.Text); err != nil {
+			return err
+		}
+	}
+	return l.Error
+}
+
+// WriteJSON scans the input to the end and writes each token to w as a JSON
+// object per line (NDJSON). The names of string and number types are written
+// as is, fmt.Stringer ones as their String result and the others are
+// formatted with fmt.Sprint, unless the name stringer is set, see
+// SetNameStringer. It returns the scanning error if any.
+//
+//   l.WriteJSON(os.Stdout)
+//   // {"name":"WORD","text":"price","line":1,"col":1}
+//   // {"name":"PRICE","text":"12","line":1,"col":7}
+func (l *Lexer) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for l.Scan() {
+		t := l.Token()
+		name := jsonName(t.Name)
+		if l.nameStringer != nil {
+			name = l.nameStringer(t.Name)
+		}
+		if err := enc.Encode(jsonToken{name, string(t.Text), t.Line, t.Column}); err != nil {
+			return err
+		}
+	}
+	return l.Error
+}
+
+// WriteCSV scans the input to the end and writes the tokens to w as CSV
+// rows of name, text, offset, line and column after the header row.
+// The names are formatted with fmt.Sprint or the name stringer, see
+// SetNameStringer. It returns the scanning error if any.
+//
+//   l.WriteCSV(os.Stdout)
+//   // name,text,offset,line,column
+//   // WORD,price,0,1,1
+//   // PRICE,12,6,1,7
+func (l *Lexer) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"name", "text", "offset", "line", "column"}); err != nil {
+		return err
+	}
+	for l.Scan() {
+		t := l.Token()
+		record := []string{
+			l.nameString(t.Name),
+			string(t.Text),
+			strconv.Itoa(t.Offset),
+			strconv.Itoa(t.Line),
+			strconv.Itoa(t.Column),
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	return l.Error
+}
+
+// WriteDOT writes the matchers to w as a Graphviz DOT graph, the nodes are
+// linked in the order the matchers are tried. The nodes of the matchers
+// built by the pattern constructors like TokenizeIfMatches, NewRegexpMatcher
+// or AddRules are labeled with the token name and the pattern, the others
+// are labeled with their index only.
+//
+//   l.WriteDOT(os.Stdout)
+//   // digraph lexer {
+//   //   rankdir=LR;
+//   //   node [shape=box];
+//   //   m0 [label="0: WORD\n[a-z]+"];
+//   //   m1 [label="1: skip\n\\s+"];
+//   //   m0 -> m1;
+//   // }
+func (l *Lexer) WriteDOT(w io.Writer) error {
+	var buf bytes.Buffer
+	buf.WriteString("digraph lexer {\n\trankdir=LR;\n\tnode [shape=box];\n")
+	n := len(l.matchers) + len(l.skips)
+	for i := 0; i < n; i++ {
+		index := i
+		if l.SkipFirst {
+			index = (i + len(l.matchers)) % n
+		}
+		fmt.Fprintf(&buf, "\tm%d [label=%s];\n", index, strconv.Quote(l.dotLabel(index)))
+		if i > 0 {
+			prev := i - 1
+			if l.SkipFirst {
+				prev = (prev + len(l.matchers)) % n
+			}
+			fmt.Fprintf(&buf, "\tm%d -> m%d;\n", prev, index)
+		}
+	}
+	buf.WriteString("}\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// dotLabel returns the label of the matcher with given index, see WriteDOT.
+func (l *Lexer) dotLabel(i int) string {
+	label := strconv.Itoa(i)
+	if i < len(l.meta) && l.meta[i].context != nil {
+		return label + ": context"
+	}
+	info := l.info(i)
+	if info == nil {
+		return label
+	}
+	if info.name == nil {
+		return label + ": skip\n" + info.pattern
+	}
+	return label + ": " + l.nameString(info.name) + "\n" + info.pattern
+}
+
+// jsonName returns the token name suitable for JSON encoding.
+func jsonName(name interface{}) interface{} {
+	switch n := name.(type) {
+	case string, nil, bool,
+		int, int8, int16, int32, int64,
+		uint, uint8, uint16, uint32, uint64,
+		float32, float64:
+		return n
+	case fmt.Stringer:
+		return n.String()
+	}
+	return fmt.Sprint(name)
+}
@@ -0,0 +1,153 @@
+package lexer_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zoer/lexer"
+)
+
+func TestLexer_WriteTokens(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer("price 12\n  цена \"13\"")
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\pL+`, "WORD"))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\d+`, "DIGIT"))
+	l.AddMatcher(lexer.TokenizeIfMatches(`"\d+"`, "STRING"))
+
+	var b bytes.Buffer
+	assert.NoError(l.WriteTokens(&b))
+	assert.Equal(`1:1 WORD "price"
+1:7 DIGIT "12"
+2:3 WORD "цена"
+2:8 STRING "\"13\""
+`, b.String())
+
+	l = lexer.NewLexer("price ?")
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	b.Reset()
+	assert.Error(l.WriteTokens(&b), "Should return the scanning error")
+	assert.Equal("1:1 WORD \"price\"\n", b.String())
+}
+
+type testName int
+
+func (n testName) String() string {
+	return fmt.Sprintf("NAME%d", int(n))
+}
+
+func TestLexer_WriteJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer("price 12\n\"x\" ?")
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\pL+`, "WORD"))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\d+`, 7))
+	l.AddMatcher(lexer.TokenizeIfMatches(`"\w+"`, testName(1)))
+	l.ErrorRecovery = true
+
+	var b bytes.Buffer
+	assert.NoError(l.WriteJSON(&b))
+	assert.Equal(`{"name":"WORD","text":"price","line":1,"col":1}
+{"name":7,"text":"12","line":1,"col":7}
+{"name":"NAME1","text":"\"x\"","line":2,"col":1}
+{"name":"ERROR","text":"?","line":2,"col":5}
+`, b.String())
+
+	l = lexer.NewLexer("price ?")
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	b.Reset()
+	assert.Error(l.WriteJSON(&b), "Should return the scanning error")
+	assert.Equal(`{"name":"WORD","text":"price","line":1,"col":1}`+"\n", b.String())
+}
+
+func TestLexer_WriteCSV(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer("price 12\n\"a,b\"")
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\pL+`, "WORD"))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\d+`, testName(2)))
+	l.AddMatcher(lexer.TokenizeIfMatches(`"[^"]*"`, "STRING"))
+
+	var b bytes.Buffer
+	assert.NoError(l.WriteCSV(&b))
+	assert.Equal(`name,text,offset,line,column
+WORD,price,0,1,1
+NAME2,12,6,1,7
+STRING,"""a,b""",9,2,1
+`, b.String())
+
+	l = lexer.NewLexer("price ?")
+	l.AddMatcher(lexer.SkipIfMatches(`\s+`))
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	b.Reset()
+	assert.Error(l.WriteCSV(&b), "Should return the scanning error")
+	assert.Equal("name,text,offset,line,column\nWORD,price,0,1,1\n", b.String())
+}
+
+func TestLexer_WriteDOT(t *testing.T) {
+	assert := assert.New(t)
+
+	l := lexer.NewLexer("")
+	l.AddMatcher(lexer.TokenizeIfMatches(`\w+`, "WORD"))
+	l.AddMatcher(lexer.KeywordMatcher(map[string]interface{}{"if": "IF"}))
+	l.AddMatcherWithPriority(lexer.TokenizeIfMatches(`"[^"]*"`, testName(1)), 1)
+	l.AddSkip(lexer.SkipIfMatches(`\s+`))
+	l.AddRules([]lexer.Rule{{Pattern: `;`, Skip: true}})
+
+	var b bytes.Buffer
+	assert.NoError(l.WriteDOT(&b))
+	assert.Equal(`digraph lexer {
+	rankdir=LR;
+	node [shape=box];
+	m0 [label="0: NAME1\n\"[^\"]*\""];
+	m1 [label="1: WORD\n\\w+"];
+	m0 -> m1;
+	m2 [label="2"];
+	m1 -> m2;
+	m3 [label="3: skip\n;"];
+	m2 -> m3;
+	m4 [label="4: skip\n\\s+"];
+	m3 -> m4;
+}
+`, b.String())
+}
+
+func TestLexer_SetNameStringer(t *testing.T) {
+	assert := assert.New(t)
+
+	names := map[interface{}]string{1: "WORD", 2: "NUMBER"}
+	newLexer := func() *lexer.Lexer {
+		l := lexer.NewLexer("price 12")
+		l.AddMatcher(lexer.TokenizeIfMatches(`[a-z]+`, 1))
+		l.AddMatcher(lexer.TokenizeIfMatches(`\d+`, 2))
+		l.AddSkip(lexer.SkipIfMatches(`\s+`))
+		return l.SetNameStringer(func(name interface{}) string {
+			return names[name]
+		})
+	}
+
+	l := newLexer()
+	assert.True(l.Scan())
+	assert.Equal(`WORD("price")`, l.Token().String())
+
+	var b bytes.Buffer
+	assert.NoError(newLexer().WriteTokens(&b))
+	assert.Equal("1:1 WORD \"price\"\n1:7 NUMBER \"12\"\n", b.String())
+
+	b.Reset()
+	assert.NoError(newLexer().WriteJSON(&b))
+	assert.Equal(`{"name":"WORD","text":"price","line":1,"col":1}
+{"name":"NUMBER","text":"12","line":1,"col":7}
+`, b.String())
+
+	l = newLexer().SetNameStringer(nil)
+	assert.True(l.Scan())
+	assert.Equal(`1("price")`, l.Token().String())
+}